@@ -0,0 +1,100 @@
+package main
+
+import (
+	"Roshamble/internal/services"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const usage = `usage:
+  roshamble                           run the server
+  roshamble roles list                list everyone holding a role
+  roshamble roles grant <user> <role> give a user (username or phone) a role
+  roshamble roles revoke <user> <role>
+  roshamble invite grant <user> <level> <reason>
+                                      raise a user's invite level
+`
+
+// Run a management command instead of the server, returns false if args are not a command
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	var err error
+	switch args[0] {
+	case "roles":
+		err = rolesCommand(args[1:])
+	case "invite":
+		err = inviteCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		err = fmt.Errorf("unknown command %q", args[0])
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+	return true
+}
+
+func rolesCommand(args []string) error {
+	if len(args) == 1 && args[0] == "list" {
+		roles, err := services.ListUserRoles(db)
+		if err != nil {
+			return err
+		}
+		for _, r := range roles {
+			fmt.Printf("%-10s %s (%s)\n", r.Role, r.Username, r.UserID)
+		}
+		return nil
+	}
+
+	if len(args) != 3 {
+		return fmt.Errorf("expected roles list, roles grant or roles revoke")
+	}
+	userID, err := services.FindUserID(db, args[1])
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "grant":
+		err = services.GrantRole(db, userID, args[2], "")
+	case "revoke":
+		err = services.RevokeRole(db, userID, args[2], "")
+	default:
+		return fmt.Errorf("unknown roles command %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s %s %s\n", args[0], args[2], args[1])
+	return nil
+}
+
+func inviteCommand(args []string) error {
+	if len(args) < 4 || args[0] != "grant" {
+		return fmt.Errorf("expected invite grant <user> <level> <reason>")
+	}
+	userID, err := services.FindUserID(db, args[1])
+	if err != nil {
+		return err
+	}
+	level, err := strconv.Atoi(args[2])
+	if err != nil {
+		return fmt.Errorf("bad invite level %q", args[2])
+	}
+
+	reason := strings.Join(args[3:], " ")
+	if err := services.GrantInviteLevel(db, userID, level, "", reason); err != nil {
+		return err
+	}
+	fmt.Printf("granted invite level %d to %s\n", level, args[1])
+	return nil
+}
@@ -5,45 +5,101 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
 )
 
-func JwtAuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		tokenString, err := c.Cookie("Authorization")
-		if tokenString == "" || err != nil {
-			c.Redirect(http.StatusFound, "/auth/login")
-			c.Abort()
-			return
-		}
+const (
+	// Short lived JWT with the player's claims
+	AccessCookie = "Authorization"
+	// Opaque token traded for a new access token once it expires
+	RefreshCookie = "Refresh"
 
-		parts := strings.Split(tokenString, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.Redirect(http.StatusFound, "/auth/login")
-			c.Abort()
-			return
+	AccessTokenLifetime = 15 * time.Minute
+	// Sessions are extended every time they are refreshed
+	SessionLifetime = 30 * 24 * time.Hour
+)
+
+// Sessions tracks signed in devices so tokens can be refreshed and revoked
+type Sessions interface {
+	// Active reports whether the session exists and has not been revoked or expired
+	Active(sessionID string) bool
+	// Refresh trades a refresh token for a new access token and refresh token
+	Refresh(c *gin.Context, refreshToken string) (string, string, error)
+}
+
+// Store both tokens in cookies, the access cookie outlives its token so an expired one still gets refreshed
+func SetSessionCookies(c *gin.Context, accessToken, refreshToken string) {
+	maxAge := int(SessionLifetime / time.Second)
+	c.SetCookie(AccessCookie, accessToken, maxAge, "/", "", false, true)
+	c.SetCookie(RefreshCookie, refreshToken, maxAge, "/", "", false, true)
+}
+
+func ClearSessionCookies(c *gin.Context) {
+	c.SetCookie(AccessCookie, "", -1, "/", "", false, true)
+	c.SetCookie(RefreshCookie, "", -1, "/", "", false, true)
+}
+
+// Parse a "Bearer <jwt>" cookie value
+func ParseAccessToken(tokenString string) (jwt.MapClaims, error) {
+	parts := strings.Split(tokenString, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, fmt.Errorf("malformed access token")
+	}
+
+	token, err := jwt.Parse(parts[1], func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		tokenString = parts[1]
+		secretKey := []byte(os.Getenv("SECRET"))
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			secretKey := []byte(os.Getenv("SECRET"))
+		return secretKey, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid access token: %w", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("unexpected claims type")
+	}
+	return claims, nil
+}
 
-			return secretKey, nil
-		})
+func JwtAuthMiddleware(sessions Sessions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, _ := c.Cookie(AccessCookie)
+		claims, err := ParseAccessToken(tokenString)
 
-		if err != nil || !token.Valid {
-			c.HTML(http.StatusOK, "index.html", gin.H{})
-			c.Abort()
-			return
+		// Expired or missing, try to pick up a fresh one with the refresh token
+		if err != nil {
+			refreshToken, _ := c.Cookie(RefreshCookie)
+			if refreshToken == "" {
+				c.Redirect(http.StatusFound, "/auth/login")
+				c.Abort()
+				return
+			}
+			accessToken, newRefreshToken, err := sessions.Refresh(c, refreshToken)
+			if err != nil {
+				ClearSessionCookies(c)
+				c.HTML(http.StatusOK, "index.html", gin.H{})
+				c.Abort()
+				return
+			}
+			SetSessionCookies(c, accessToken, newRefreshToken)
+			if claims, err = ParseAccessToken(accessToken); err != nil {
+				c.Redirect(http.StatusFound, "/auth/login")
+				c.Abort()
+				return
+			}
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
+		// Signed out elsewhere
+		sessionID, _ := claims["sid"].(string)
+		if sessionID == "" || !sessions.Active(sessionID) {
+			ClearSessionCookies(c)
 			c.Redirect(http.StatusFound, "/auth/login")
 			c.Abort()
 			return
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// Roles stored in user_roles, players without a row are plain users
+const (
+	RoleGuest     = "guest"
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Each role can do everything the roles below it can
+var roleRank = map[string]int{
+	RoleGuest:     0,
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// HasRole reports whether any of the roles is at least the required one
+func HasRole(roles []string, required string) bool {
+	for _, r := range roles {
+		if rank, ok := roleRank[r]; ok && rank >= roleRank[required] {
+			return true
+		}
+	}
+	return false
+}
+
+// Roles stored in the claims set by JwtAuthMiddleware
+func ClaimRoles(claims jwt.MapClaims) []string {
+	roles := []string{}
+	// Decoded tokens hold []interface{}, claims updated in place hold []string
+	switch raw := claims["roles"].(type) {
+	case []string:
+		roles = append(roles, raw...)
+	case []interface{}:
+		for _, r := range raw {
+			if role, ok := r.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// RequireRole only lets players with the role or a higher one through, it must run after JwtAuthMiddleware
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get("claims")
+		claims, ok := value.(jwt.MapClaims)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		roles := ClaimRoles(claims)
+		if len(roles) == 0 {
+			roles = []string{RoleUser}
+		}
+		if !HasRole(roles, role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
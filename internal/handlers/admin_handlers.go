@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"Roshamble/internal/services"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type roleRequest struct {
+	// Username or phone number
+	User string `form:"user" json:"user" binding:"required"`
+	Role string `form:"role" json:"role" binding:"required"`
+}
+
+func (h *Handler) ListRoles(c *gin.Context) {
+	roles, err := services.ListUserRoles(h.DB)
+	if err != nil {
+		slog.Error("Error listing roles", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list roles"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+func (h *Handler) GrantRole(c *gin.Context) {
+	h.changeRole(c, services.GrantRole)
+}
+
+func (h *Handler) RevokeRole(c *gin.Context) {
+	h.changeRole(c, services.RevokeRole)
+}
+
+type inviteGrantRequest struct {
+	// Username or phone number
+	User   string `form:"user" json:"user" binding:"required"`
+	Level  int    `form:"level" json:"level" binding:"required"`
+	Reason string `form:"reason" json:"reason" binding:"required"`
+}
+
+// Raise a player's invite level by hand, e.g. to let a friend of the house into a closed tournament
+func (h *Handler) GrantInviteLevel(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not signed in"})
+		return
+	}
+
+	req := inviteGrantRequest{}
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user, level and reason are required"})
+		return
+	}
+
+	userID, err := services.FindUserID(h.DB, req.User)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.GrantInviteLevel(h.DB, userID, req.Level, claims.ID, req.Reason); err != nil {
+		if errors.Is(err, services.ErrInvalidInviteLevel) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		slog.Error("Error granting invite level", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not grant invite level"})
+		return
+	}
+
+	level, err := services.GetInviteLevel(h.DB, userID)
+	if err != nil {
+		slog.Error("Error getting invite level", "error", err)
+	}
+	slog.Info("Invite level granted", "userID", userID, "level", req.Level, "by", claims.Username, "reason", req.Reason)
+	c.JSON(http.StatusOK, gin.H{"userID": userID, "inviteLevel": level})
+}
+
+func (h *Handler) changeRole(c *gin.Context, change func(db *sql.DB, userID, role, changedBy string) error) {
+	claims, err := getClaims(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not signed in"})
+		return
+	}
+
+	req := roleRequest{}
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user and role are required"})
+		return
+	}
+
+	userID, err := services.FindUserID(h.DB, req.User)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := change(h.DB, userID, req.Role, claims.ID); err != nil {
+		if errors.Is(err, services.ErrLastAdmin) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		slog.Error("Error changing role", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	roles, err := services.GetUserRoles(h.DB, userID)
+	if err != nil {
+		slog.Error("Error getting user roles", "error", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"userID": userID, "roles": roles})
+}
+
+func (h *Handler) AdminSeasons(c *gin.Context) {
+	seasons, err := services.ListSeasons(h.DB)
+	if err != nil {
+		slog.Error("Error listing seasons", "error", err)
+	}
+	c.HTML(http.StatusOK, "seasons.html", gin.H{"Seasons": seasons})
+}
+
+// End the current season, everyone's rating is softened the first time they play in the next one
+func (h *Handler) StartSeason(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		adminResult(c, http.StatusBadRequest, "Name required", "Give the new season a name", "/admin/seasons")
+		return
+	}
+	season, err := services.StartSeason(h.DB, name)
+	if err != nil {
+		slog.Error("Error starting season", "error", err)
+		adminResult(c, http.StatusInternalServerError, "Something went wrong", "Could not start the season", "/admin/seasons")
+		return
+	}
+	slog.Info("Season started", "seasonID", season.ID, "name", season.Name, "by", claims.Username)
+	c.Redirect(http.StatusFound, "/admin/seasons")
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"Roshamble/internal/services"
+	"Roshamble/internal/tournament"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long the admin console waits on a tournament loop to apply a command
+const commandTimeout = 5 * time.Second
+
+var errTournamentNotLoaded = errors.New("tournament is not running")
+
+// Send a command to a tournament in memory and wait for it to be applied, returning the command's result
+func (h *Handler) sendCommand(tournamentID int, username string, cmd tournament.Command) (any, error) {
+	st, ok := h.Tournaments.Load(tournamentID)
+	if !ok {
+		return nil, errTournamentNotLoaded
+	}
+	t := st.(*tournament.Tournament)
+
+	reply := make(chan tournament.GameResponse, 1)
+	if !t.Send(tournament.GameCommand{Username: username, Command: cmd, Response: reply}) {
+		return nil, errTournamentNotLoaded
+	}
+
+	select {
+	case resp := <-reply:
+		if resp.Command == tournament.ReplyError {
+			if err, ok := resp.Payload.(error); ok {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%v", resp.Payload)
+		}
+		return resp.Payload, nil
+	case <-time.After(commandTimeout):
+		return nil, fmt.Errorf("tournament did not respond to %s", cmd.Name())
+	}
+}
+
+// Renders the tournament form, new tournaments start from the default settings
+func tournamentForm(c *gin.Context, status int, t services.Tournament, formErr string) {
+	c.HTML(status, "tournament_form.html", gin.H{
+		"Tournament": &t,
+		"Formats":    tournament.FormatNames(),
+		"MoveSets":   tournament.MoveSetNames(),
+		"Error":      formErr,
+	})
+}
+
+func adminResult(c *gin.Context, status int, title, message, url string) {
+	c.HTML(status, "redirector.html", gin.H{"Title": title, "Message": message, "URL": url})
+}
+
+func (h *Handler) AdminTournaments(c *gin.Context) {
+	ts, err := services.ListTournaments(h.DB, 100)
+	if err != nil {
+		slog.Error("Error listing tournaments", "error", err)
+	}
+	c.HTML(http.StatusOK, "tournaments.html", gin.H{"Tournaments": ts})
+}
+
+func (h *Handler) NewTournament(c *gin.Context) {
+	tournamentForm(c, http.StatusOK, services.Tournament{
+		InviteLevel:    1,
+		StartDate:      time.Now().Add(24 * time.Hour).Truncate(time.Hour).Format(time.RFC3339Nano),
+		RoundTimeout:   int(tournament.DefaultRoundTimeout / time.Second),
+		Format:         tournament.FormatSwiss,
+		BestOf:         tournament.DefaultRuleset.BestOf,
+		MoveSet:        tournament.DefaultRuleset.MoveSet.Name,
+		CheckInMinutes: 15,
+	}, "")
+}
+
+func (h *Handler) CreateTournament(c *gin.Context) {
+	t, err := services.CreateTournament(c, h.DB)
+	if errors.Is(err, services.ErrInvalidTournament) {
+		tournamentForm(c, http.StatusBadRequest, t, err.Error())
+		return
+	} else if err != nil {
+		tournamentForm(c, http.StatusInternalServerError, t, "Could not create the tournament, please try again")
+		return
+	}
+	slog.Info("Tournament created", "tournamentID", t.ID, "name", t.Name)
+	c.Redirect(http.StatusFound, fmt.Sprintf("/admin/tournaments/%d", t.ID))
+}
+
+func (h *Handler) EditTournament(c *gin.Context) {
+	t, err := services.GetTournament(c, h.DB)
+	if err != nil || t.ID == 0 {
+		adminResult(c, http.StatusNotFound, "Tournament not found", "That tournament does not exist", "/admin/tournaments")
+		return
+	}
+	if !t.Editable() {
+		adminResult(c, http.StatusConflict, "Too late", "Tournaments cannot be changed once they have started", fmt.Sprintf("/admin/tournaments/%d", t.ID))
+		return
+	}
+	tournamentForm(c, http.StatusOK, t, "")
+}
+
+func (h *Handler) UpdateTournament(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+	tID, ok := tournamentParam(c)
+	if !ok {
+		return
+	}
+
+	t, err := services.UpdateTournament(c, h.DB, tID)
+	switch {
+	case errors.Is(err, services.ErrInvalidTournament):
+		tournamentForm(c, http.StatusBadRequest, t, err.Error())
+		return
+	case errors.Is(err, services.ErrTournamentStarted):
+		adminResult(c, http.StatusConflict, "Too late", err.Error(), fmt.Sprintf("/admin/tournaments/%d", tID))
+		return
+	case err != nil:
+		tournamentForm(c, http.StatusInternalServerError, t, "Could not save the tournament, please try again")
+		return
+	}
+
+	// Players may already be waiting on it, so the loop needs the new start date and settings too
+	_, err = h.sendCommand(tID, claims.Username, tournament.RescheduleCommand{StartDate: t.StartTime(), Config: t.GameConfig()})
+	if err != nil && !errors.Is(err, errTournamentNotLoaded) {
+		slog.Error("Error rescheduling tournament in memory", "tournamentID", tID, "error", err)
+	}
+	slog.Info("Tournament updated", "tournamentID", tID, "by", claims.Username)
+	c.Redirect(http.StatusFound, fmt.Sprintf("/admin/tournaments/%d", tID))
+}
+
+func (h *Handler) CloneTournament(c *gin.Context) {
+	tID, ok := tournamentParam(c)
+	if !ok {
+		return
+	}
+
+	id, err := services.CloneTournament(h.DB, tID)
+	if err != nil {
+		slog.Error("Error cloning tournament", "tournamentID", tID, "error", err)
+		adminResult(c, http.StatusInternalServerError, "Something went wrong", "Could not clone the tournament", "/admin/tournaments")
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/admin/tournaments/%d/edit", id))
+}
+
+func (h *Handler) CancelTournament(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+	tID, ok := tournamentParam(c)
+	if !ok {
+		return
+	}
+	url := fmt.Sprintf("/admin/tournaments/%d", tID)
+
+	reason := strings.TrimSpace(c.PostForm("reason"))
+	if reason == "" {
+		reason = "The tournament was cancelled"
+	}
+
+	// Players in the waiting room or mid game hear about it from the tournament itself
+	_, err = h.sendCommand(tID, claims.Username, tournament.CancelCommand{Reason: reason})
+	if errors.Is(err, errTournamentNotLoaded) {
+		err = services.NewTournamentStore(h.DB).CancelTournament(tID)
+	}
+	if err != nil {
+		slog.Error("Error cancelling tournament", "tournamentID", tID, "error", err)
+		adminResult(c, http.StatusConflict, "Could not cancel", err.Error(), url)
+		return
+	}
+	slog.Info("Tournament cancelled", "tournamentID", tID, "by", claims.Username, "reason", reason)
+	adminResult(c, http.StatusOK, "Cancelled", "Entry fees have been refunded", url)
+}
+
+// Live bracket, the page polls itself while the tournament is running
+func (h *Handler) TournamentBracket(c *gin.Context) {
+	t, err := services.GetTournament(c, h.DB)
+	if err != nil || t.ID == 0 {
+		adminResult(c, http.StatusNotFound, "Tournament not found", "That tournament does not exist", "/admin/tournaments")
+		return
+	}
+
+	matches, err := services.GetBracket(h.DB, t.ID)
+	if err != nil {
+		slog.Error("Error loading bracket", "tournamentID", t.ID, "error", err)
+	}
+	registered, err := services.CountRegistrations(h.DB, t.ID)
+	if err != nil {
+		slog.Error("Error counting tournament registrations", "error", err)
+	}
+	_, loaded := h.Tournaments.Load(t.ID)
+
+	c.HTML(http.StatusOK, "bracket.html", gin.H{
+		"Tournament":   &t,
+		"Matches":      matches,
+		"CurrentMatch": len(matches),
+		"Registered":   registered,
+		"Live":         loaded && t.Status == services.TournamentRunning,
+	})
+}
+
+// Settle a disputed game still being played in the current match, an empty winner calls it a draw
+func (h *Handler) ResolveGame(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+	tID, ok := tournamentParam(c)
+	if !ok {
+		return
+	}
+	url := fmt.Sprintf("/admin/tournaments/%d", tID)
+
+	reason := strings.TrimSpace(c.PostForm("reason"))
+	if reason == "" {
+		adminResult(c, http.StatusBadRequest, "Reason required", "Say why the game is being resolved", url)
+		return
+	}
+
+	result, err := h.sendCommand(tID, claims.Username, tournament.ResolveGameCommand{
+		GameID:         c.Param("gameID"),
+		WinnerUsername: c.PostForm("winner"),
+	})
+	if err != nil {
+		adminResult(c, http.StatusConflict, "Could not resolve game", err.Error(), url)
+		return
+	}
+
+	game := result.(tournament.Game)
+	winnerID := ""
+	switch game.WinnerUsername {
+	case "":
+	case game.Player1.Username:
+		winnerID = game.Player1.ID
+	default:
+		winnerID = game.Player2.ID
+	}
+	if err := services.RecordGameResolution(h.DB, tID, game.ID, winnerID, claims.ID, reason); err != nil {
+		slog.Error("Error recording game resolution", "gameID", game.ID, "error", err)
+	}
+	slog.Info("Game resolved", "tournamentID", tID, "gameID", game.ID, "winner", game.WinnerUsername, "by", claims.Username)
+	adminResult(c, http.StatusOK, "Game resolved", "The result has been recorded", url)
+}
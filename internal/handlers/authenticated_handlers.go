@@ -1,13 +1,19 @@
 package handlers
 
 import (
+	"Roshamble/internal/auth"
+	"Roshamble/internal/protocol"
 	"Roshamble/internal/services"
 	"Roshamble/internal/tournament"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
@@ -30,9 +36,52 @@ func getClaims(c *gin.Context) (services.Claims, error) {
 		ID:       claims["id"].(string),
 		Username: claims["username"].(string),
 	}
+	structClaims.SessionID, _ = claims["sid"].(string)
+	structClaims.Roles = auth.ClaimRoles(claims)
+	// Numbers come back from the token as floats
+	switch lvl := claims["invitelvl"].(type) {
+	case float64:
+		structClaims.InviteLevel = int(lvl)
+	case int:
+		structClaims.InviteLevel = lvl
+	}
 	return structClaims, nil
 }
 
+// Reissue the token when the player's invite level or roles have changed since it was signed
+func (h *Handler) RefreshClaims(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		c.Next()
+		return
+	}
+
+	level, roles, err := services.GetUserAccess(h.DB, claims.ID)
+	if err != nil || (level == claims.InviteLevel && slices.Equal(roles, claims.Roles)) {
+		if err != nil && err != sql.ErrNoRows {
+			slog.Error("Error getting invite level and roles", "error", err)
+		}
+		c.Next()
+		return
+	}
+
+	claims.InviteLevel = level
+	claims.Roles = roles
+	token, err := services.IssueToken(claims)
+	if err != nil {
+		slog.Error("Error signing token", "error", err)
+		c.Next()
+		return
+	}
+	c.SetCookie(auth.AccessCookie, token, int(auth.SessionLifetime/time.Second), "/", "", false, true)
+	if mapClaims, ok := c.MustGet("claims").(jwt.MapClaims); ok {
+		mapClaims["invitelvl"] = level
+		mapClaims["roles"] = roles
+	}
+	slog.Info("Refreshed token with new invite level and roles", "userID", claims.ID, "level", level, "roles", roles)
+	c.Next()
+}
+
 func (h *Handler) GetDashboard(c *gin.Context) {
 	claims, err := getClaims(c)
 	if err != nil {
@@ -51,7 +100,12 @@ func (h *Handler) GetDashboard(c *gin.Context) {
 	slog.Info("open tournament", "openID", tournamentData.OpenTournament.ID)
 	slog.Info("ongoing tournament", "ongoing", tournamentData.OngoingTournament.ID)
 
-	c.HTML(http.StatusOK, "dashboard.html", gin.H{"Claims": claims, "OpenTournament": tournamentData.OpenTournament, "OpenTournamentCountDown": "30 seconds", "OngoingTournament": tournamentData.OngoingTournament, "LastTournamentDate": tournamentData.LastTournament.GetDateTimeString(), "UpcomingTournaments": tournamentData.UpcomingTournaments})
+	credits, err := services.GetCreditBalance(h.DB, claims.ID)
+	if err != nil {
+		slog.Error("Error getting credit balance", "error", err)
+	}
+
+	c.HTML(http.StatusOK, "dashboard.html", gin.H{"Claims": claims, "Credits": credits, "OpenTournament": tournamentData.OpenTournament, "OpenTournamentCountDown": "30 seconds", "OngoingTournament": tournamentData.OngoingTournament, "LastTournamentDate": tournamentData.LastTournament.GetDateTimeString(), "UpcomingTournaments": tournamentData.UpcomingTournaments})
 }
 
 func (h *Handler) GetPastTournaments(c *gin.Context) {
@@ -81,10 +135,75 @@ func (h *Handler) GetProfile(c *gin.Context) {
 		return
 	}
 
-	c.HTML(http.StatusOK, "profile.html", gin.H{"Username": p.Username, "Email": p.Email, "NewTournaments": p.NewTournamentsNotif, "FriendsJoined": p.FriendsJoinedNotif, "TournamentStarting": p.TournamentStartingNotif})
+	balance, err := services.GetCreditBalance(h.DB, claims.ID)
+	if err != nil {
+		slog.Error("Error getting credit balance", "error", err)
+	}
+	history, err := services.GetCreditHistory(h.DB, claims.ID, 20)
+	if err != nil {
+		slog.Error("Error getting credit history", "error", err)
+	}
+
+	progress, err := services.GetPlayerProgress(h.DB, claims.ID)
+	if err != nil {
+		slog.Error("Error getting invite level progress", "error", err)
+	}
+	next, hasNext := progress.NextLevel()
+
+	sessions, err := services.ListSessions(h.DB, claims.ID, claims.SessionID)
+	if err != nil {
+		slog.Error("Error listing sessions", "error", err)
+	}
+
+	stats, err := services.GetPlayerStats(h.DB, claims.ID)
+	if err != nil {
+		slog.Error("Error getting player stats", "error", err)
+	}
+
+	c.HTML(http.StatusOK, "profile.html", gin.H{"Stats": stats, "Sessions": sessions, "Progress": progress, "NextLevel": next, "HasNextLevel": hasNext, "Username": p.Username, "Email": p.Email, "NewTournaments": p.NewTournamentsNotif, "FriendsJoined": p.FriendsJoinedNotif, "TournamentStarting": p.TournamentStartingNotif, "Credits": balance, "CreditHistory": history})
 	return
 }
 
+// Stats as JSON, the signed in player's own or another player's by username
+func (h *Handler) GetStats(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil || claims.ID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not signed in"})
+		return
+	}
+
+	userID, username := claims.ID, claims.Username
+	if u := c.Param("username"); u != "" {
+		userID, err = services.UserIDByUsername(h.DB, u)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such player"})
+			return
+		} else if err != nil {
+			slog.Error("Error looking up player", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load stats"})
+			return
+		}
+		username = u
+	}
+
+	stats, err := services.GetPlayerStats(h.DB, userID)
+	if err != nil {
+		slog.Error("Error getting player stats", "userID", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load stats"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"username":      username,
+		"stats":         stats,
+		"winRate":       stats.WinRate(),
+		"drawRate":      stats.DrawRate(),
+		"lossRate":      stats.LossRate(),
+		"roundWinRate":  stats.RoundWinRate(),
+		"roundDrawRate": stats.RoundDrawRate(),
+		"roundLossRate": stats.RoundLossRate(),
+	})
+}
+
 func (h *Handler) UpdateProfile(c *gin.Context) {
 	claims, err := getClaims(c)
 	if err != nil {
@@ -99,7 +218,7 @@ func (h *Handler) UpdateProfile(c *gin.Context) {
 }
 
 func (h *Handler) GetPlay(c *gin.Context) {
-	_, err := getClaims(c)
+	claims, err := getClaims(c)
 	if err != nil {
 		slog.Error("Error getting claims", "error", err)
 		c.Redirect(http.StatusFound, "/auth/login")
@@ -116,40 +235,143 @@ func (h *Handler) GetPlay(c *gin.Context) {
 		return
 	}
 
+	if dbT.InviteLevel > claims.InviteLevel {
+		c.HTML(http.StatusForbidden, "redirector.html", gin.H{"Title": "Not yet", "Message": fmt.Sprintf("This tournament is open to invite level %d and up", dbT.InviteLevel), "URL": "/"})
+		return
+	}
+
+	if dbT.Status == services.TournamentFinished || dbT.Status == services.TournamentCancelled {
+		c.HTML(http.StatusOK, "redirector.html", gin.H{"Title": "Tournament over", "Message": "This tournament has already " + dbT.Status})
+		return
+	}
+
 	// Initialize tournament if it doesn't exist
-	st, ok := h.Tournaments.Load(dbT.ID)
-	if !ok {
-		slog.Info("Tournament not found in memory, creating new tournament")
-		st = tournament.NewTournament(dbT.ID)
-		h.Tournaments.Store(dbT.ID, st)
-		st, ok = h.Tournaments.Load(dbT.ID)
-		if !ok {
-			slog.Error("Error storing new tournament in memory")
-			return
+	if dbT.Editable() {
+		if err := h.LoadTournament(dbT); errors.Is(err, services.ErrTournamentOwned) {
+			slog.Info("Tournament is running on another server", "tournamentID", dbT.ID)
+		} else if err != nil {
+			slog.Error("Error loading tournament", "tournamentID", dbT.ID, "error", err)
 		}
 	}
 
-	c.HTML(http.StatusOK, "play.html", gin.H{"Error": "", "Countdown": "", "InviteLink": "", "Tournament": dbT})
+	registration, err := services.GetRegistration(h.DB, dbT.ID, claims.ID)
+	if err != nil {
+		slog.Error("Error getting tournament registration", "error", err)
+	}
+	registered, err := services.CountRegistrations(h.DB, dbT.ID)
+	if err != nil {
+		slog.Error("Error counting tournament registrations", "error", err)
+	}
+	credits, err := services.GetCreditBalance(h.DB, claims.ID)
+	if err != nil {
+		slog.Error("Error getting credit balance", "error", err)
+	}
+
+	c.HTML(http.StatusOK, "play.html", gin.H{
+		"Error":        "",
+		"Countdown":    "",
+		"InviteLink":   "",
+		"Tournament":   dbT,
+		"Moves":        dbT.GameConfig().Ruleset.MoveSet.Moves,
+		"Registration": registration,
+		"Registered":   registered,
+		"Confirmed":    registration.Confirmed(dbT),
+		"CheckInOpen":  dbT.CheckInMinutes > 0 && time.Now().After(dbT.CheckInOpensAt()),
+		"CheckInOpens": dbT.CheckInOpensAt().Format("3:04 PM"),
+		"StartsAt":     dbT.StartTime().Format("3:04 PM"),
+		"Credits":      credits,
+	})
 }
 
-func (h *Handler) LeaveTournament(c *gin.Context) {
+// Parses the tournament ID url param, rendering an error if it is missing or malformed
+func tournamentParam(c *gin.Context) (int, bool) {
+	tID, err := strconv.Atoi(c.Param("tournamentID"))
+	if err != nil {
+		slog.Error("Error parsing tournamentID from url param", "error", err.Error())
+		c.HTML(http.StatusBadRequest, "redirector.html", gin.H{"Title": "Tournament not found", "Message": "That tournament does not exist", "URL": "/"})
+		return 0, false
+	}
+	return tID, true
+}
+
+// Renders the outcome of a registration change, sending the player back to the tournament page
+func registrationResult(c *gin.Context, tID int, err error, title, message string) {
+	url := fmt.Sprintf("/play/%d", tID)
+	switch {
+	case err == nil:
+		c.HTML(http.StatusOK, "redirector.html", gin.H{"Title": title, "Message": message, "URL": url})
+	case errors.Is(err, services.ErrRegistrationClosed), errors.Is(err, services.ErrTournamentFull), errors.Is(err, services.ErrTournamentStarted),
+		errors.Is(err, services.ErrNotRegistered), errors.Is(err, services.ErrCheckInClosed), errors.Is(err, services.ErrInsufficientCredits),
+		errors.Is(err, services.ErrInviteLevelTooLow):
+		c.HTML(http.StatusConflict, "redirector.html", gin.H{"Title": "Sorry", "Message": err.Error(), "URL": url})
+	default:
+		c.HTML(http.StatusInternalServerError, "redirector.html", gin.H{"Title": "Something went wrong", "Message": "Please try again", "URL": url})
+	}
+}
+
+func (h *Handler) JoinTournament(c *gin.Context) {
 	claims, err := getClaims(c)
 	if err != nil {
 		slog.Error("Error getting claims", "error", err)
 		c.Redirect(http.StatusFound, "/auth/login")
+		return
 	}
 
-	tIDStr := c.Param("tournamentID")
-	tID, err := strconv.Atoi(tIDStr)
+	tID, ok := tournamentParam(c)
+	if !ok {
+		return
+	}
+
+	err = services.AddTournamentPlayer(c, h.DB, tID, claims)
 	if err != nil {
-		slog.Error("Error parsing tournamentID from url param", "error", err.Error())
+		slog.Error("Error adding player to tournament", "error", err.Error())
+	}
+	registrationResult(c, tID, err, "Registered", "Check in shortly before the tournament starts to claim your spot")
+}
+
+func (h *Handler) CheckInTournament(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		slog.Error("Error getting claims", "error", err)
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+
+	tID, ok := tournamentParam(c)
+	if !ok {
+		return
+	}
+
+	err = services.CheckInTournamentPlayer(c, h.DB, tID, claims)
+	if err != nil {
+		slog.Error("Error checking player in to tournament", "error", err.Error())
+	}
+	registrationResult(c, tID, err, "Checked in", "You're in, stay on the tournament page until it starts")
+}
+
+func (h *Handler) LeaveTournament(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		slog.Error("Error getting claims", "error", err)
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+
+	tID, ok := tournamentParam(c)
+	if !ok {
+		return
 	}
 
 	err = services.RemoveTournamentPlayer(c, h.DB, tID, claims)
 	if err != nil {
 		slog.Error("Error removing player from tournament", "error", err.Error())
+	} else if st, ok := h.Tournaments.Load(tID); ok {
+		st.(*tournament.Tournament).Send(tournament.GameCommand{
+			Username: claims.Username,
+			Command:  tournament.LeaveCommand{},
+		})
 	}
-	c.HTML(http.StatusOK, "redirector.html", gin.H{"Title": "Leaving tournament", "Message": "You may join again before the tournament starts"})
+	registrationResult(c, tID, err, "Leaving tournament", "You may join again before the tournament starts")
 }
 
 var upgrader = websocket.Upgrader{
@@ -179,11 +401,11 @@ func (h *Handler) WsHandler(c *gin.Context) {
 	}
 
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	defer conn.Close()
 	if err != nil {
 		slog.Error("Error upgrading websocket connection", "error", err)
 		return
 	}
+	defer conn.Close()
 	slog.Info("Websocket connection established")
 
 	tID, err := strconv.Atoi(tournamentID)
@@ -197,57 +419,66 @@ func (h *Handler) WsHandler(c *gin.Context) {
 		return
 	}
 
-	recvChan := make(chan tournament.GameResponse)
+	h.play(conn, claims, st.(*tournament.Tournament))
+}
+
+// Join the player to the tournament loop and relay messages both ways until the socket closes
+func (h *Handler) play(conn *websocket.Conn, claims services.Claims, t *tournament.Tournament) {
+	tID := t.ID
+	pc := newPlayConn(conn, tID)
+	if err := pc.send(protocol.TypeWelcome, "", protocol.Welcome{Version: protocol.Version, MinVersion: protocol.MinVersion}); err != nil {
+		slog.Error("Error writing welcome", "error", err)
+		return
+	}
+
+	// Buffered so a slow socket never stalls the tournament loop
+	recvChan := make(chan tournament.GameResponse, 32)
 	player := tournament.Player{
+		ID:       claims.ID,
 		Username: claims.Username,
 		MsgChan:  recvChan,
 		WinCount: 0,
 	}
 
-	t := st.(*tournament.Tournament)
-	t.CommandChan <- tournament.GameCommand{
+	if !t.Send(tournament.GameCommand{
 		Username: claims.Username,
-		Command:  "join",
-		Payload:  &player,
+		Command:  tournament.JoinCommand{Player: &player},
+	}) {
+		slog.Error("Tournament already over", "tournamentID", tID)
+		return
 	}
 	slog.Info("Player joined tournament", "username", player.Username)
 
-	var data map[string]any
-
+	// A reconnect swaps in a new channel and this one goes quiet, so the writer also stops with the reader
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
 		for {
-			msg := []byte{}
 			select {
+			case <-done:
+				return
+			case <-t.Done():
+				// Flush whatever the tournament said last before it closed
+				for {
+					select {
+					case cmd := <-recvChan:
+						pc.send(cmd.Command, cmd.GameID, cmd.Payload)
+					default:
+						return
+					}
+				}
 			case cmd := <-recvChan:
 				switch cmd.Command {
-				case "gameWon":
-					msg = []byte(fmt.Sprintf("You won the game! %s", cmd.Payload))
-
-				case "gameLost":
-					msg = []byte(fmt.Sprintf("You lost the game! %s", cmd.Payload))
-
-				case "gameDraw":
-					msg = []byte(fmt.Sprintf("The game ended in a draw! %s", cmd.Payload))
-
-				case "moveAccepted":
-					msg = []byte(fmt.Sprintf("Your move was accepted! %s Waiting for other player...", cmd.Payload))
-
-				case "gameStarted":
-					msg = []byte(fmt.Sprintf("The game has started! GameID: %s", cmd.Payload))
-
-				case "gameEnded":
-					msg = []byte(fmt.Sprintf("The game has ended! %s", cmd.Payload))
-				case "tournamentEnded":
-					msg = []byte(fmt.Sprintf("The tournament has ended! %s is the winner!", cmd.Payload))
-
-				default:
-					slog.Error("Unknown command received from tournament", "command", cmd.Command)
+				case tournament.ReplyOK:
+					continue
+				case tournament.ReplyError:
+					pc.sendError(protocol.ErrRejected, fmt.Sprint(cmd.Payload), cmd.Seq)
+					continue
+				}
+				if err := pc.send(cmd.Command, cmd.GameID, cmd.Payload); err != nil {
+					slog.Error("Error writing message", "error", err)
+					return
 				}
-			}
-			err = conn.WriteMessage(websocket.BinaryMessage, msg)
-			if err != nil {
-				slog.Error("Error writing message", "error", err)
-				return
 			}
 		}
 	}()
@@ -258,23 +489,87 @@ func (h *Handler) WsHandler(c *gin.Context) {
 			slog.Error("Error reading message", "error", err)
 			return
 		}
-		slog.Info("Message received", "message", string(msg))
+		slog.Debug("Message received", "message", string(msg))
 
-		err = json.Unmarshal(msg, &data)
-		if err != nil {
-			slog.Error("Error unmarshalling message", "error", err)
-			return
+		env := protocol.Envelope{}
+		if err := json.Unmarshal(msg, &env); err != nil {
+			pc.sendError(protocol.ErrBadMessage, "Messages must be JSON envelopes", 0)
+			continue
 		}
 
-		if move, ok := data["move"].(string); ok {
-			slog.Info("Move received", "move", move)
-			t.CommandChan <- tournament.GameCommand{
+		switch env.Type {
+		case protocol.TypeHello:
+			hello := protocol.Hello{}
+			if err := json.Unmarshal(env.Payload, &hello); err != nil {
+				pc.sendError(protocol.ErrBadMessage, "Invalid hello payload", env.Seq)
+				continue
+			}
+			// A hello without a version is as good as no hello at all
+			if hello.Version == 0 {
+				hello.Version = protocol.Version
+			}
+			version, ok := protocol.Negotiate(hello.Version)
+			if !ok {
+				pc.sendError(protocol.ErrUnsupportedVersion, fmt.Sprintf("Protocol version %d is no longer supported, minimum is %d", hello.Version, protocol.MinVersion), env.Seq)
+				return
+			}
+			slog.Info("Negotiated play protocol version", "username", claims.Username, "version", version)
+
+		case protocol.TypeMove:
+			move := protocol.Move{}
+			if err := json.Unmarshal(env.Payload, &move); err != nil || move.Move == "" {
+				pc.sendError(protocol.ErrBadMessage, "Invalid move payload", env.Seq)
+				continue
+			}
+			slog.Info("Move received", "move", move.Move)
+			if !t.Send(tournament.GameCommand{
 				Username: claims.Username,
-				Command:  "move",
-				Payload:  move,
+				Command:  tournament.MoveCommand{Move: move.Move},
+				Response: recvChan,
+				Seq:      env.Seq,
+			}) {
+				return
 			}
 
+		default:
+			pc.sendError(protocol.ErrUnknownType, fmt.Sprintf("Unknown message type %q", env.Type), env.Seq)
 		}
+	}
+}
+
+// Sign out a single device from the profile page
+func (h *Handler) SignOutDevice(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		slog.Error("Error getting claims", "error", err)
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+
+	sessionID := c.Param("sessionID")
+	if err := services.RevokeSession(h.DB, claims.ID, sessionID); err != nil {
+		slog.Error("Error revoking session", "error", err)
+	}
+
+	if sessionID == claims.SessionID {
+		auth.ClearSessionCookies(c)
+		c.Header("HX-Redirect", "/auth/login")
+		return
+	}
+	h.GetProfile(c)
+}
+
+func (h *Handler) SignOutEverywhere(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		slog.Error("Error getting claims", "error", err)
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
 
+	if err := services.RevokeAllSessions(h.DB, claims.ID); err != nil {
+		slog.Error("Error revoking sessions", "error", err)
 	}
+	auth.ClearSessionCookies(c)
+	c.Header("HX-Redirect", "/auth/login")
 }
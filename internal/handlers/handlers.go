@@ -1,10 +1,17 @@
 package handlers
 
 import (
+	"Roshamble/internal/auth"
+	"Roshamble/internal/matchmaking"
 	"Roshamble/internal/services"
+	"Roshamble/internal/sms"
+	"Roshamble/internal/tournament"
+	"context"
 	"database/sql"
+	"errors"
 	"log/slog"
 	"net/http"
+	"os"
 	"sync"
 
 	"github.com/gin-gonic/gin"
@@ -12,7 +19,91 @@ import (
 
 type Handler struct {
 	DB          *sql.DB
+	SMS         sms.Sender
 	Tournaments sync.Map // map[int]*tournament.Tournament
+	Matchmaker  *matchmaking.Matchmaker
+	Matches     sync.Map // map[int]*tournament.Tournament, quickplay matches by ID
+	Locks       *services.TournamentLocker
+}
+
+// Keep a tournament in memory until it finishes or is cancelled, holding its lock for as long as it runs
+func (h *Handler) storeTournament(t *tournament.Tournament, lock *services.TournamentLock) {
+	if _, loaded := h.Tournaments.LoadOrStore(t.ID, t); loaded {
+		// Lost the race to another request, drop the duplicate
+		t.Stop()
+		lock.Release()
+		return
+	}
+	go func() {
+		<-t.Done()
+		h.Tournaments.Delete(t.ID)
+		lock.Release()
+		slog.Info("Tournament evicted from memory", "tournamentID", t.ID)
+	}()
+}
+
+// Stop running a tournament another server has taken over, it is evicted like any other stopped tournament
+func (h *Handler) DropTournament(tournamentID int) {
+	st, ok := h.Tournaments.Load(tournamentID)
+	if !ok {
+		return
+	}
+	slog.Error("Stopping tournament now owned by another server", "tournamentID", tournamentID)
+	st.(*tournament.Tournament).Stop()
+}
+
+// Run a tournament that has not started yet on this server, opening it if it is still only scheduled.
+// Returns ErrTournamentOwned if another server is already running it.
+func (h *Handler) LoadTournament(dbT services.Tournament) error {
+	if _, ok := h.Tournaments.Load(dbT.ID); ok {
+		return nil
+	}
+
+	lock, err := h.Locks.Lock(context.Background(), dbT.ID)
+	if err != nil {
+		return err
+	}
+
+	// Another server may have run it to completion between loading it and taking the lock
+	dbT, err = services.GetTournamentByID(h.DB, dbT.ID)
+	if err != nil || !dbT.Editable() {
+		lock.Release()
+		return err
+	}
+
+	if dbT.Status == services.TournamentScheduled {
+		if err := services.UpdateTournamentStatus(h.DB, dbT.ID, services.TournamentOpen); err != nil {
+			slog.Error("Error opening tournament", "error", err)
+		}
+	}
+	slog.Info("Loading tournament into memory", "tournamentID", dbT.ID, "startDate", dbT.StartDate)
+	h.storeTournament(tournament.NewTournament(dbT.ID, dbT.StartTime(), services.NewTournamentStore(h.DB), dbT.GameConfig()), lock)
+	return nil
+}
+
+// Reload every running tournament from the database so players can reconnect after a restart.
+// Tournaments another server is already running are left to it.
+func (h *Handler) RestoreTournaments() error {
+	snaps, err := services.LoadRunningTournaments(h.DB)
+	if err != nil {
+		return err
+	}
+	restored := 0
+	for _, snap := range snaps {
+		if _, ok := h.Tournaments.Load(snap.ID); ok {
+			continue
+		}
+		lock, err := h.Locks.Lock(context.Background(), snap.ID)
+		if errors.Is(err, services.ErrTournamentOwned) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		h.storeTournament(tournament.Restore(snap, services.NewTournamentStore(h.DB)), lock)
+		restored++
+	}
+	slog.Info("Restored running tournaments", "count", restored)
+	return nil
 }
 
 func (h *Handler) Empty(c *gin.Context) {
@@ -20,7 +111,7 @@ func (h *Handler) Empty(c *gin.Context) {
 }
 
 func (h *Handler) GetLogin(c *gin.Context) {
-	if cookie, err := c.Cookie("Authorization"); err != nil && cookie != "" {
+	if cookie, err := c.Cookie(auth.AccessCookie); err != nil && cookie != "" {
 		c.HTML(http.StatusOK, "dashboard.html", gin.H{})
 		return
 	}
@@ -29,7 +120,7 @@ func (h *Handler) GetLogin(c *gin.Context) {
 }
 
 func (h *Handler) TriggerOTP(c *gin.Context) {
-	phone, message, errMessage := services.TriggerOTP(c, h.DB)
+	phone, message, errMessage := services.TriggerOTP(c, h.DB, h.SMS)
 	if errMessage != "" {
 		c.HTML(http.StatusOK, "login.html", gin.H{"message": errMessage})
 		return
@@ -38,23 +129,73 @@ func (h *Handler) TriggerOTP(c *gin.Context) {
 }
 
 func (h *Handler) Login(c *gin.Context) {
-	token, errMessage := services.VerifyUser(c, h.DB)
-	slog.Info("User logged in", "token", token, "errMessage", errMessage)
+	accessToken, refreshToken, errMessage := services.VerifyUser(c, h.DB)
 	if errMessage != "" {
 		c.HTML(http.StatusOK, "login.html", gin.H{"Error": errMessage})
 		return
 	}
+	slog.Info("User logged in")
 
-	c.SetCookie("Authorization", token, 3600*24*365, "/", "", false, true)
+	auth.SetSessionCookies(c, accessToken, refreshToken)
+	c.SetCookie(services.ReferralCookie, "", -1, "/", "", false, true)
 	c.Header("HX-Redirect", "/")
 }
 
+// Remember who sent the invite link so they can be rewarded once the visitor signs up and plays
+func (h *Handler) Referral(c *gin.Context) {
+	referrerID := c.Param("id")
+	if services.ValidReferrer(h.DB, referrerID) {
+		c.SetCookie(services.ReferralCookie, referrerID, 3600*24*30, "/", "", false, true)
+	}
+	c.Redirect(http.StatusFound, "/auth/login")
+}
+
 func (h *Handler) Logout(c *gin.Context) {
-	c.SetCookie("Authorization", "", -1, "/", "", false, true)
+	if refreshToken, err := c.Cookie(auth.RefreshCookie); err == nil && refreshToken != "" {
+		if err := services.RevokeSessionByRefreshToken(h.DB, refreshToken); err != nil {
+			slog.Error("Error revoking session", "error", err)
+		}
+	}
+	auth.ClearSessionCookies(c)
 	c.Header("HX-Redirect", "/")
 	c.HTML(http.StatusOK, "index.html", gin.H{})
 }
 
+// Delivery status updates posted by the SMS provider, only accepted when signed with the Twilio auth token
+func (h *Handler) SMSStatus(c *gin.Context) {
+	if err := c.Request.ParseForm(); err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	// Without a token there is no way to tell Twilio from anyone else
+	token := os.Getenv("TWILIO_AUTH_TOKEN")
+	if token == "" {
+		slog.Warn("Rejected sms status callback, TWILIO_AUTH_TOKEN is not set")
+		c.Status(http.StatusForbidden)
+		return
+	}
+	twilio := sms.Twilio{AuthToken: token}
+	if !twilio.ValidSignature(os.Getenv("SMS_STATUS_CALLBACK_URL"), c.Request.PostForm, c.GetHeader("X-Twilio-Signature")) {
+		slog.Warn("Rejected sms status callback with a bad signature")
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	providerID := c.PostForm("MessageSid")
+	status := c.PostForm("MessageStatus")
+	if providerID == "" || status == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	if err := services.UpdateSMSStatus(h.DB, providerID, status, c.PostForm("ErrorCode")); err != nil {
+		slog.Error("Error updating sms status", "error", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
 func (h *Handler) PingHandler(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "pong"})
 }
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"Roshamble/internal/services"
+	"Roshamble/internal/tournament"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+const leaderboardSize = 50
+
+var boardNames = map[string]string{
+	services.BoardWins:    "Wins",
+	services.BoardTitles:  "Titles",
+	services.BoardWinRate: "Win Rate",
+	services.BoardStreak:  "Streak",
+	services.BoardRating:  "Rating",
+}
+
+var windowNames = map[string]string{
+	services.WindowWeek:  "This Week",
+	services.WindowMonth: "This Month",
+	services.WindowAll:   "All Time",
+}
+
+func (h *Handler) GetLeaderboard(c *gin.Context) {
+	board := c.DefaultQuery("board", services.BoardWins)
+	if !slices.Contains(services.Boards, board) {
+		board = services.BoardWins
+	}
+	window := c.DefaultQuery("window", services.WindowAll)
+	if !slices.Contains(services.Windows, window) {
+		window = services.WindowAll
+	}
+
+	entries, err := services.GetLeaderboard(h.DB, board, window, leaderboardSize)
+	if err != nil {
+		slog.Error("Error getting leaderboard", "board", board, "window", window, "error", err)
+	}
+
+	// Tournaments being played right now have live standings
+	live := []services.Tournament{}
+	ts, err := services.ListTournaments(h.DB, 20)
+	if err != nil {
+		slog.Error("Error listing tournaments", "error", err)
+	}
+	for _, t := range ts {
+		if t.Status == services.TournamentRunning {
+			live = append(live, t)
+		}
+	}
+
+	c.HTML(http.StatusOK, "leaderboard.html", gin.H{
+		"Board":       board,
+		"Window":      window,
+		"Boards":      services.Boards,
+		"Windows":     services.Windows,
+		"BoardNames":  boardNames,
+		"WindowNames": windowNames,
+		"Entries":     entries,
+		"MinGames":    services.MinWinRateGames,
+		"Live":        live,
+	})
+}
+
+// Standings of a tournament in progress, the page polls itself while it runs
+func (h *Handler) GetStandings(c *gin.Context) {
+	tID, ok := tournamentParam(c)
+	if !ok {
+		return
+	}
+	t, err := services.GetTournamentByID(h.DB, tID)
+	if err != nil || t.ID == 0 {
+		c.HTML(http.StatusNotFound, "redirector.html", gin.H{"Title": "Tournament not found", "Message": "That tournament does not exist", "URL": "/"})
+		return
+	}
+
+	standings := tournament.LiveStandings{}
+	result, err := h.sendCommand(tID, "", tournament.StandingsCommand{})
+	switch {
+	case err == nil:
+		standings = result.(tournament.LiveStandings)
+	case !errors.Is(err, errTournamentNotLoaded):
+		slog.Error("Error getting live standings", "tournamentID", tID, "error", err)
+	}
+
+	c.HTML(http.StatusOK, "standings.html", gin.H{
+		"Tournament": &t,
+		"Standings":  standings,
+		"Live":       err == nil && t.Status == services.TournamentRunning,
+	})
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"Roshamble/internal/protocol"
+	"encoding/json"
+	"log/slog"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// playConn serialises writes to a play socket and numbers the envelopes sent on it
+type playConn struct {
+	conn         *websocket.Conn
+	tournamentID int
+
+	mu  sync.Mutex
+	seq int
+}
+
+func newPlayConn(conn *websocket.Conn, tournamentID int) *playConn {
+	return &playConn{
+		conn:         conn,
+		tournamentID: tournamentID,
+	}
+}
+
+func (pc *playConn) send(msgType, gameID string, payload any) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pc.seq++
+	env, err := protocol.NewEnvelope(msgType, pc.seq, pc.tournamentID, gameID, payload)
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return pc.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+func (pc *playConn) sendError(code, message string, replyTo int) {
+	err := pc.send(protocol.TypeError, "", protocol.Error{Code: code, Message: message, ReplyTo: replyTo})
+	if err != nil {
+		slog.Error("Error writing error message", "error", err)
+	}
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"Roshamble/internal/matchmaking"
+	"Roshamble/internal/services"
+	"Roshamble/internal/tournament"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Time for both players to get from the ready check to the game page before the first round starts
+const quickplayCountdown = 5 * time.Second
+
+// StartQuickplay creates the game for a match both players accepted and runs it in memory until it finishes
+func (h *Handler) StartQuickplay(m matchmaking.Match) (int, error) {
+	id, err := services.CreateQuickplayMatch(h.DB, m)
+	if err != nil {
+		return 0, err
+	}
+
+	t := tournament.NewTournament(id, time.Now().Add(quickplayCountdown), services.NewQuickplayStore(h.DB, m.Mode == matchmaking.ModeRanked), services.QuickplayConfig())
+	h.Matches.Store(id, t)
+	go func() {
+		<-t.Done()
+		h.Matches.Delete(id)
+		slog.Info("Quickplay match evicted from memory", "matchID", id)
+	}()
+	return id, nil
+}
+
+// Navigate the whole page, htmx would otherwise follow a plain redirect and swap the result into the target
+func hxRedirect(c *gin.Context, url string) {
+	if c.GetHeader("HX-Request") != "" {
+		c.Header("HX-Redirect", url)
+		c.Status(http.StatusOK)
+		return
+	}
+	c.Redirect(http.StatusFound, url)
+}
+
+// The mode and player ID url params, the player ID has to be the signed in player's
+func queueParams(c *gin.Context, claims services.Claims) (string, bool) {
+	mode := c.Param("mode")
+	if !matchmaking.ValidMode(mode) {
+		c.HTML(http.StatusNotFound, "redirector.html", gin.H{"Title": "Not found", "Message": "That game mode does not exist", "URL": "/"})
+		return "", false
+	}
+	if id := c.Param("id"); id != "" && id != claims.ID {
+		c.AbortWithStatus(http.StatusForbidden)
+		return "", false
+	}
+	return mode, true
+}
+
+// Join matchmaking and show the queue
+func (h *Handler) GetQueue(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		slog.Error("Error getting claims", "error", err)
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+	mode, ok := queueParams(c, claims)
+	if !ok {
+		return
+	}
+
+	player := matchmaking.Player{ID: claims.ID, Username: claims.Username}
+	if mode == matchmaking.ModeRanked {
+		r, err := services.GetRating(h.DB, claims.ID)
+		if err != nil {
+			slog.Error("Error getting rating", "error", err)
+		}
+		player.Rating = r.Rating
+	}
+	if err := h.Matchmaker.Join(mode, player); err != nil {
+		slog.Error("Error joining matchmaking", "error", err)
+	}
+	h.queueStatus(c, claims)
+}
+
+func (h *Handler) LeaveQueue(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		slog.Error("Error getting claims", "error", err)
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+	if _, ok := queueParams(c, claims); !ok {
+		return
+	}
+
+	h.Matchmaker.Leave(claims.ID)
+	hxRedirect(c, "/")
+}
+
+// Polled by the queue and ready check pages
+func (h *Handler) QueueStatus(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		slog.Error("Error getting claims", "error", err)
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+	if _, ok := queueParams(c, claims); !ok {
+		return
+	}
+	h.queueStatus(c, claims)
+}
+
+// Render wherever the player is in matchmaking, sending them to their game once it has started
+func (h *Handler) queueStatus(c *gin.Context, claims services.Claims) {
+	status := h.Matchmaker.Status(claims.ID)
+
+	switch status.State {
+	case matchmaking.StateNone:
+		// Left, declined or did not accept in time
+		hxRedirect(c, "/")
+	case matchmaking.StateSearching:
+		c.HTML(http.StatusOK, "queue.html", gin.H{
+			"Mode":           status.Mode,
+			"FormattedMode":  matchmaking.ModeName(status.Mode),
+			"PlayerID":       claims.ID,
+			"CurrentPlayers": status.Searching,
+			"Window":         status.Window,
+			"Error":          status.Message,
+		})
+	case matchmaking.StateReadyCheck:
+		c.HTML(http.StatusOK, "match_ready.html", gin.H{
+			"Mode":        status.Mode,
+			"PlayerID":    claims.ID,
+			"MatchID":     status.Match.ID,
+			"Player1":     status.Match.Player1,
+			"Player2":     status.Match.Player2,
+			"Accepted":    status.Accepted,
+			"SecondsLeft": int(math.Ceil(time.Until(status.Match.Deadline).Seconds())),
+		})
+	case matchmaking.StateMatched:
+		hxRedirect(c, fmt.Sprintf("/match/%d", status.Match.GameID))
+	}
+}
+
+func (h *Handler) AcceptMatch(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		slog.Error("Error getting claims", "error", err)
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+
+	if err := h.Matchmaker.Accept(claims.ID, c.Param("matchID")); err != nil && !errors.Is(err, matchmaking.ErrNoMatch) {
+		slog.Error("Error accepting match", "error", err)
+	}
+	h.queueStatus(c, claims)
+}
+
+func (h *Handler) DeclineMatch(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		slog.Error("Error getting claims", "error", err)
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+
+	h.Matchmaker.Decline(claims.ID)
+	hxRedirect(c, "/")
+}
+
+// Parses the quickplay match ID url param, only the two players in the match get through
+func (h *Handler) matchParam(c *gin.Context, claims services.Claims) (int, bool) {
+	matchID, err := strconv.Atoi(c.Param("matchID"))
+	if err != nil {
+		c.HTML(http.StatusNotFound, "redirector.html", gin.H{"Title": "Game not found", "Message": "That game does not exist", "URL": "/"})
+		return 0, false
+	}
+	in, err := services.InQuickplayMatch(h.DB, matchID, claims.ID)
+	if err != nil {
+		slog.Error("Error checking quickplay match", "matchID", matchID, "error", err)
+	}
+	if !in {
+		c.HTML(http.StatusForbidden, "redirector.html", gin.H{"Title": "Not your game", "Message": "You are not playing in this game", "URL": "/"})
+		return 0, false
+	}
+	return matchID, true
+}
+
+func (h *Handler) GetMatch(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		slog.Error("Error getting claims", "error", err)
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+	matchID, ok := h.matchParam(c, claims)
+	if !ok {
+		return
+	}
+	if _, ok := h.Matches.Load(matchID); !ok {
+		c.HTML(http.StatusOK, "redirector.html", gin.H{"Title": "Game over", "Message": "This game has already finished", "URL": "/"})
+		return
+	}
+
+	cfg := services.QuickplayConfig()
+	c.HTML(http.StatusOK, "game.html", gin.H{
+		"MatchID":          matchID,
+		"BestOf":           cfg.Ruleset.BestOf,
+		"Moves":            cfg.Ruleset.MoveSet.Moves,
+		"ConnectionStatus": "Connecting...",
+	})
+}
+
+func (h *Handler) MatchWsHandler(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		slog.Error("Error getting claims", "error", err)
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+	matchID, ok := h.matchParam(c, claims)
+	if !ok {
+		return
+	}
+	st, ok := h.Matches.Load(matchID)
+	if !ok {
+		slog.Error("Quickplay match not found", "matchID", matchID)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		slog.Error("Error upgrading websocket connection", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	// Envelopes carry the match ID where tournament sockets carry the tournament ID
+	h.play(conn, claims, st.(*tournament.Tournament))
+}
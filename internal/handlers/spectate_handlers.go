@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"Roshamble/internal/protocol"
+	"Roshamble/internal/services"
+	"Roshamble/internal/tournament"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Watch a tournament, the bracket so far is rendered and the live games come over the spectate socket
+func (h *Handler) GetSpectate(c *gin.Context) {
+	t, err := services.GetTournament(c, h.DB)
+	if err != nil || t.ID == 0 {
+		c.HTML(http.StatusNotFound, "redirector.html", gin.H{"Title": "Tournament not found", "Message": "That tournament does not exist", "URL": "/"})
+		return
+	}
+
+	matches, err := services.GetBracket(h.DB, t.ID)
+	if err != nil {
+		slog.Error("Error loading bracket", "tournamentID", t.ID, "error", err)
+	}
+	_, loaded := h.Tournaments.Load(t.ID)
+
+	c.HTML(http.StatusOK, "spectate.html", gin.H{
+		"Tournament": &t,
+		"Matches":    matches,
+		"Live":       loaded,
+	})
+}
+
+func (h *Handler) SpectateWsHandler(c *gin.Context) {
+	claims, err := getClaims(c)
+	if err != nil {
+		slog.Error("Error getting claims", "error", err)
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+	tID, ok := tournamentParam(c)
+	if !ok {
+		return
+	}
+	st, ok := h.Tournaments.Load(tID)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		slog.Error("Error upgrading websocket connection", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	h.spectate(conn, claims, st.(*tournament.Tournament))
+}
+
+// Send the spectator a snapshot and then every event they are allowed to see until either side goes away
+func (h *Handler) spectate(conn *websocket.Conn, claims services.Claims, t *tournament.Tournament) {
+	pc := newPlayConn(conn, t.ID)
+	if err := pc.send(protocol.TypeWelcome, "", protocol.Welcome{Version: protocol.Version, MinVersion: protocol.MinVersion}); err != nil {
+		slog.Error("Error writing welcome", "error", err)
+		return
+	}
+
+	// Not through sendCommand, a reply that arrives after a timeout would leave the subscription open
+	reply := make(chan tournament.GameResponse, 1)
+	if !t.Send(tournament.GameCommand{Username: claims.Username, Command: tournament.SpectateCommand{}, Response: reply}) {
+		return
+	}
+	var spec tournament.Spectation
+	select {
+	case resp := <-reply:
+		spec = resp.Payload.(tournament.Spectation)
+	case <-t.Done():
+		return
+	}
+	defer spec.Cancel()
+	slog.Info("Spectator joined", "tournamentID", t.ID, "username", claims.Username)
+
+	if err := pc.send(protocol.TypeSnapshot, "", spec.Snapshot); err != nil {
+		slog.Error("Error writing snapshot", "error", err)
+		return
+	}
+
+	// Spectators only ever say hello, closing the socket ends the stream
+	go func() {
+		defer spec.Cancel()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			env := protocol.Envelope{}
+			if err := json.Unmarshal(msg, &env); err != nil || env.Type != protocol.TypeHello {
+				pc.sendError(protocol.ErrBadMessage, "Spectators can only say hello", env.Seq)
+				continue
+			}
+			hello := protocol.Hello{}
+			if err := json.Unmarshal(env.Payload, &hello); err != nil {
+				pc.sendError(protocol.ErrBadMessage, "Invalid hello payload", env.Seq)
+				continue
+			}
+			if hello.Version == 0 {
+				hello.Version = protocol.Version
+			}
+			if _, ok := protocol.Negotiate(hello.Version); !ok {
+				pc.sendError(protocol.ErrUnsupportedVersion, "Protocol version is no longer supported", env.Seq)
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	for e := range spec.Events {
+		msgType, gameID, payload := tournament.SpectatorMessage(e)
+		if msgType == "" {
+			continue
+		}
+		if err := pc.send(msgType, gameID, payload); err != nil {
+			slog.Error("Error writing spectator message", "error", err)
+			return
+		}
+	}
+	slog.Info("Spectator left", "tournamentID", t.ID, "username", claims.Username)
+}
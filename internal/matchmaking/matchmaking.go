@@ -0,0 +1,411 @@
+package matchmaking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ModeQuickplay = "quickplay"
+	// Rated games against players of a similar rating
+	ModeRanked = "ranked"
+
+	// How long both players have to accept a match once it is found
+	ReadyCheck = 15 * time.Second
+	// Searching players poll for their status, anyone who stops is taken out of the queue
+	StaleAfter = 10 * time.Second
+
+	// Ranked players are paired within this many rating points of each other to begin with,
+	// widening the longer they wait so nobody waits forever
+	BaseWindow      = 100
+	WindowPerSecond = 10
+	MaxWindow       = 800
+)
+
+// Where a player is in matchmaking
+const (
+	StateNone       = ""
+	StateSearching  = "searching"
+	StateReadyCheck = "readyCheck"
+	// Both players accepted and the game has been created
+	StateMatched = "matched"
+)
+
+var (
+	ErrUnknownMode = errors.New("unknown matchmaking mode")
+	ErrNoMatch     = errors.New("match is no longer waiting on you")
+)
+
+func ValidMode(mode string) bool {
+	return mode == ModeQuickplay || mode == ModeRanked
+}
+
+func ModeName(mode string) string {
+	if mode == ModeRanked {
+		return "Ranked"
+	}
+	return "Quick Play"
+}
+
+type Player struct {
+	ID       string
+	Username string
+	// Only used to pair ranked players
+	Rating float64
+}
+
+// Match is a pairing waiting on, or past, its ready check
+type Match struct {
+	ID       string
+	Mode     string
+	Player1  Player
+	Player2  Player
+	Deadline time.Time
+	Accepted [2]bool
+	// ID of the game once both players have accepted and it has been started
+	GameID int
+
+	starting bool
+}
+
+func (m *Match) slot(playerID string) int {
+	switch playerID {
+	case m.Player1.ID:
+		return 0
+	case m.Player2.ID:
+		return 1
+	}
+	return -1
+}
+
+// Status is a player's view of matchmaking
+type Status struct {
+	State string
+	Mode  string
+	// Copy of the player's match during and after the ready check
+	Match Match
+	// Whether the player has accepted their match
+	Accepted bool
+	// Players searching in the same mode
+	Searching int
+	// How far from their rating a ranked player will currently be paired
+	Window int
+	// Why the player is back in the queue, if they are
+	Message string
+}
+
+type entry struct {
+	player   Player
+	mode     string
+	joinedAt time.Time
+	lastSeen time.Time
+	match    *Match
+	message  string
+}
+
+func (e *entry) window(now time.Time) float64 {
+	return math.Min(BaseWindow+WindowPerSecond*now.Sub(e.joinedAt).Seconds(), MaxWindow)
+}
+
+func (e *entry) state() string {
+	switch {
+	case e.match == nil:
+		return StateSearching
+	case e.match.GameID != 0:
+		return StateMatched
+	default:
+		return StateReadyCheck
+	}
+}
+
+// StartFunc creates the game for a match both players accepted and returns its ID
+type StartFunc func(m Match) (int, error)
+
+// Matchmaker pairs up players waiting in the same mode, first come first served
+type Matchmaker struct {
+	mu      sync.Mutex
+	queues  map[string][]*entry
+	players map[string]*entry
+	start   StartFunc
+}
+
+func New(start StartFunc) *Matchmaker {
+	return &Matchmaker{
+		queues:  map[string][]*entry{},
+		players: map[string]*entry{},
+		start:   start,
+	}
+}
+
+// Join puts a player in the queue for a mode. Players already searching in that mode keep their place,
+// players in another mode leave it first so they are never matched twice.
+func (mm *Matchmaker) Join(mode string, player Player) error {
+	if !ValidMode(mode) {
+		return fmt.Errorf("%w %q", ErrUnknownMode, mode)
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := mm.players[player.ID]; ok {
+		switch {
+		case e.state() == StateMatched:
+			// Their last game has started, this is a new search
+			delete(mm.players, player.ID)
+		case e.mode != mode:
+			if e.state() == StateReadyCheck {
+				mm.decline(e, "Your opponent left the queue, searching again")
+			} else {
+				mm.remove(e)
+			}
+		default:
+			e.lastSeen = now
+			return nil
+		}
+	}
+
+	e := &entry{player: player, mode: mode, joinedAt: now, lastSeen: now}
+	mm.players[player.ID] = e
+	mm.queues[mode] = append(mm.queues[mode], e)
+	mm.pair(mode, now)
+	return nil
+}
+
+// Leave takes a player out of matchmaking, declining their match if they have one
+func (mm *Matchmaker) Leave(playerID string) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	e, ok := mm.players[playerID]
+	if !ok {
+		return
+	}
+	if e.state() == StateReadyCheck {
+		mm.decline(e, "Your opponent declined, searching again")
+		return
+	}
+	mm.remove(e)
+}
+
+func (mm *Matchmaker) Status(playerID string) Status {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	now := time.Now()
+	mm.expire(now)
+
+	e, ok := mm.players[playerID]
+	if !ok {
+		return Status{State: StateNone}
+	}
+	e.lastSeen = now
+
+	status := Status{
+		State:     e.state(),
+		Mode:      e.mode,
+		Searching: len(mm.queues[e.mode]),
+		Message:   e.message,
+	}
+	if e.mode == ModeRanked {
+		status.Window = int(e.window(now))
+	}
+	if e.match != nil {
+		status.Match = *e.match
+		status.Accepted = e.match.Accepted[e.match.slot(playerID)]
+	}
+	return status
+}
+
+// Accept the player's match, starting the game once both players have accepted
+func (mm *Matchmaker) Accept(playerID, matchID string) error {
+	mm.mu.Lock()
+	e, ok := mm.players[playerID]
+	if !ok || e.match == nil || e.match.ID != matchID || e.state() != StateReadyCheck || time.Now().After(e.match.Deadline) {
+		mm.mu.Unlock()
+		return ErrNoMatch
+	}
+	m := e.match
+	m.Accepted[m.slot(playerID)] = true
+	if !m.Accepted[0] || !m.Accepted[1] || m.starting {
+		mm.mu.Unlock()
+		return nil
+	}
+
+	// Creating the game hits the database, so let everyone else carry on in the meantime
+	m.starting = true
+	match := *m
+	mm.mu.Unlock()
+
+	gameID, err := mm.start(match)
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	m.starting = false
+	if err != nil {
+		slog.Error("Error starting matched game", "matchID", m.ID, "error", err)
+		for _, p := range []Player{m.Player1, m.Player2} {
+			if pe, ok := mm.players[p.ID]; ok && pe.match == m {
+				mm.requeue(pe, "Something went wrong starting your game, searching again")
+			}
+		}
+		mm.pair(m.Mode, time.Now())
+		return err
+	}
+	m.GameID = gameID
+	slog.Info("Matched game started", "matchID", m.ID, "gameID", gameID, "mode", m.Mode)
+	return nil
+}
+
+// Decline the player's match, their opponent goes back to the front of the queue
+func (mm *Matchmaker) Decline(playerID string) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if e, ok := mm.players[playerID]; ok && e.state() == StateReadyCheck {
+		mm.decline(e, "Your opponent declined, searching again")
+	}
+}
+
+// Run expires ready checks, drops players who stopped polling and pairs ranked players as their
+// windows widen until the context is cancelled
+func (mm *Matchmaker) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			mm.mu.Lock()
+			mm.expire(now)
+			for mode := range mm.queues {
+				mm.pair(mode, now)
+			}
+			mm.mu.Unlock()
+		}
+	}
+}
+
+// The longest waiting player after queue[i] that it can be paired with, or -1
+func partner(mode string, queue []*entry, i int, now time.Time) int {
+	for j := i + 1; j < len(queue); j++ {
+		if mode != ModeRanked {
+			return j
+		}
+		window := math.Min(queue[i].window(now), queue[j].window(now))
+		if math.Abs(queue[i].player.Rating-queue[j].player.Rating) <= window {
+			return j
+		}
+	}
+	return -1
+}
+
+// Pair off the longest waiting players in a mode, ranked players only within both of their
+// rating windows. Must be called with the lock held.
+func (mm *Matchmaker) pair(mode string, now time.Time) {
+	queue := mm.queues[mode]
+	for i := 0; i < len(queue); i++ {
+		j := partner(mode, queue, i, now)
+		if j < 0 {
+			continue
+		}
+		p1, p2 := queue[i], queue[j]
+		rest := make([]*entry, 0, len(queue)-2)
+		for k, e := range queue {
+			if k != i && k != j {
+				rest = append(rest, e)
+			}
+		}
+		queue = rest
+		// Whoever moved up into i gets their turn
+		i--
+
+		m := &Match{
+			ID:       uuid.New().String(),
+			Mode:     mode,
+			Player1:  p1.player,
+			Player2:  p2.player,
+			Deadline: now.Add(ReadyCheck),
+		}
+		p1.match, p1.message = m, ""
+		p2.match, p2.message = m, ""
+		slog.Info("Match found", "matchID", m.ID, "mode", mode, "player1", p1.player.Username, "player2", p2.player.Username)
+	}
+	mm.queues[mode] = queue
+}
+
+// Must be called with the lock held
+func (mm *Matchmaker) expire(now time.Time) {
+	for _, e := range mm.players {
+		switch e.state() {
+		case StateSearching, StateMatched:
+			if now.Sub(e.lastSeen) > StaleAfter {
+				mm.remove(e)
+			}
+		case StateReadyCheck:
+			m := e.match
+			if m.starting || now.Before(m.Deadline) {
+				continue
+			}
+			// Whoever accepted keeps their place, whoever did not is out
+			for i, p := range []Player{m.Player1, m.Player2} {
+				pe, ok := mm.players[p.ID]
+				if !ok || pe.match != m {
+					continue
+				}
+				if m.Accepted[i] {
+					mm.requeue(pe, "Your opponent did not accept in time, searching again")
+				} else {
+					mm.remove(pe)
+				}
+			}
+			mm.pair(m.Mode, now)
+		}
+	}
+}
+
+// Must be called with the lock held
+func (mm *Matchmaker) decline(e *entry, message string) {
+	m := e.match
+	for _, p := range []Player{m.Player1, m.Player2} {
+		pe, ok := mm.players[p.ID]
+		if !ok || pe.match != m {
+			continue
+		}
+		if pe == e {
+			mm.remove(pe)
+		} else {
+			mm.requeue(pe, message)
+		}
+	}
+	mm.pair(m.Mode, time.Now())
+}
+
+// Put a player back at the front of their queue, they have already waited once. Must be called with the lock held.
+func (mm *Matchmaker) requeue(e *entry, message string) {
+	e.match = nil
+	e.message = message
+	e.lastSeen = time.Now()
+	mm.queues[e.mode] = append([]*entry{e}, mm.queues[e.mode]...)
+}
+
+// Must be called with the lock held
+func (mm *Matchmaker) remove(e *entry) {
+	delete(mm.players, e.player.ID)
+	queue := mm.queues[e.mode]
+	for i, qe := range queue {
+		if qe == e {
+			mm.queues[e.mode] = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+}
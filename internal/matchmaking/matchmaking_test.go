@@ -0,0 +1,97 @@
+package matchmaking
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPartner(t *testing.T) {
+	now := time.Date(2025, time.April, 7, 12, 0, 0, 0, time.UTC)
+	waiting := func(rating float64, seconds int) *entry {
+		return &entry{player: Player{Rating: rating}, joinedAt: now.Add(-time.Duration(seconds) * time.Second)}
+	}
+
+	tests := []struct {
+		name  string
+		mode  string
+		queue []*entry
+		want  int
+	}{
+		{"within the base window", ModeRanked, []*entry{waiting(1500, 0), waiting(1600, 0)}, 1},
+		{"outside the base window", ModeRanked, []*entry{waiting(1500, 0), waiting(1700, 0)}, -1},
+		{"window widened", ModeRanked, []*entry{waiting(1500, 10), waiting(1700, 10)}, 1},
+		{"only one has waited", ModeRanked, []*entry{waiting(1500, 60), waiting(1700, 0)}, -1},
+		{"window capped", ModeRanked, []*entry{waiting(1000, 3600), waiting(1900, 3600)}, -1},
+		{"longest waiting in range", ModeRanked, []*entry{waiting(1500, 30), waiting(2200, 30), waiting(1350, 20), waiting(1550, 5)}, 2},
+		{"quickplay ignores ratings", ModeQuickplay, []*entry{waiting(1000, 0), waiting(2500, 0)}, 1},
+		{"nobody else waiting", ModeQuickplay, []*entry{waiting(1500, 0)}, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := partner(tt.mode, tt.queue, 0, now); got != tt.want {
+				t.Errorf("partner = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoinAnotherMode(t *testing.T) {
+	noStart := func(m Match) (int, error) { return 0, nil }
+	alice := Player{ID: "alice", Username: "alice", Rating: 1500}
+	bob := Player{ID: "bob", Username: "bob", Rating: 2500}
+
+	t.Run("searching", func(t *testing.T) {
+		mm := New(noStart)
+		if err := mm.Join(ModeQuickplay, alice); err != nil {
+			t.Fatal(err)
+		}
+		if err := mm.Join(ModeRanked, alice); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(mm.queues[ModeQuickplay]); n != 0 {
+			t.Errorf("%d players left in the quickplay queue", n)
+		}
+
+		// Far outside alice's ranked window, so only a leftover quickplay entry could pair them
+		if err := mm.Join(ModeQuickplay, bob); err != nil {
+			t.Fatal(err)
+		}
+		if s := mm.Status(alice.ID); s.State != StateSearching || s.Mode != ModeRanked {
+			t.Errorf("alice is %q in %q, want searching in ranked", s.State, s.Mode)
+		}
+		if s := mm.Status(bob.ID); s.State != StateSearching {
+			t.Errorf("bob is %q, want searching", s.State)
+		}
+	})
+
+	t.Run("ready check", func(t *testing.T) {
+		mm := New(noStart)
+		mm.Join(ModeQuickplay, alice)
+		mm.Join(ModeQuickplay, bob)
+		if s := mm.Status(alice.ID); s.State != StateReadyCheck {
+			t.Fatalf("alice is %q, want in a ready check", s.State)
+		}
+
+		if err := mm.Join(ModeRanked, alice); err != nil {
+			t.Fatal(err)
+		}
+		if s := mm.Status(alice.ID); s.State != StateSearching || s.Mode != ModeRanked {
+			t.Errorf("alice is %q in %q, want searching in ranked", s.State, s.Mode)
+		}
+		if s := mm.Status(bob.ID); s.State != StateSearching || s.Mode != ModeQuickplay || s.Message == "" {
+			t.Errorf("bob is %q in %q, want back searching in quickplay with a message", s.State, s.Mode)
+		}
+	})
+
+	t.Run("same mode keeps its place", func(t *testing.T) {
+		mm := New(noStart)
+		mm.Join(ModeRanked, alice)
+		mm.Join(ModeRanked, bob)
+		mm.Join(ModeRanked, alice)
+		queue := mm.queues[ModeRanked]
+		if len(queue) != 2 || queue[0].player.ID != alice.ID {
+			t.Errorf("alice lost their place in the ranked queue")
+		}
+	})
+}
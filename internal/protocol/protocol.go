@@ -0,0 +1,268 @@
+// Package protocol describes the JSON messages sent over the /ws/play/:tournamentID socket.
+//
+// Every frame in either direction is a text frame holding one Envelope. The server numbers its
+// envelopes with an increasing Seq per connection, clients may echo their own Seq so errors can be
+// matched to the command that caused them.
+//
+// On connect the server sends a "welcome" carrying the versions it speaks. Clients should answer
+// with a "hello" naming the version they speak. There is only one version so far, so for now the
+// hello just closes the connection with an "error" if the client is older than MinVersion.
+// Clients that skip the hello, or leave the version out of it, are assumed to speak Version.
+//
+// The read-only /ws/spectate/:tournamentID socket uses the same envelopes and handshake. It opens
+// with a "snapshot" of the current match and then streams the spectator messages below. Moves are
+// only ever shown once both players have committed to the round.
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// Version of the protocol spoken by this server
+	Version = 1
+	// MinVersion is the oldest client version the server still understands
+	MinVersion = 1
+)
+
+// Envelope wraps every message sent over the socket
+type Envelope struct {
+	Type       string          `json:"type"`
+	Seq        int             `json:"seq"`
+	Tournament int             `json:"tournament"`
+	Game       string          `json:"game,omitempty"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+}
+
+// Server to client message types
+const (
+	TypeWelcome             = "welcome"
+	TypeError               = "error"
+	TypeGameStarted         = "gameStarted"
+	TypeGameResumed         = "gameResumed"
+	TypeMoveAccepted        = "moveAccepted"
+	TypeRoundFinished       = "roundFinished"
+	TypeRoundCountdown      = "roundCountdown"
+	TypeGameWon             = "gameWon"
+	TypeGameLost            = "gameLost"
+	TypeGameDraw            = "gameDraw"
+	TypeTournamentEnded     = "tournamentEnded"
+	TypeTournamentCancelled = "tournamentCancelled"
+	TypeNotEntered          = "notEntered"
+)
+
+// Spectator message types, tournamentEnded and tournamentCancelled are shared with players
+const (
+	TypeSnapshot          = "snapshot"
+	TypePlayerJoined      = "playerJoined"
+	TypePlayerLeft        = "playerLeft"
+	TypeTournamentStarted = "tournamentStarted"
+	TypeGamePaired        = "gamePaired"
+	TypeMoveCommitted     = "moveCommitted"
+	TypeRoundRevealed     = "roundRevealed"
+	TypeGameFinished      = "gameFinished"
+	TypeMatchEnded        = "matchEnded"
+)
+
+// Client to server message types
+const (
+	TypeHello = "hello"
+	TypeMove  = "move"
+)
+
+// Error codes sent in an Error payload
+const (
+	ErrBadMessage         = "bad_message"
+	ErrUnknownType        = "unknown_type"
+	ErrUnsupportedVersion = "unsupported_version"
+	ErrRejected           = "rejected"
+)
+
+// Welcome is sent as soon as the socket is connected
+type Welcome struct {
+	Version    int `json:"version"`
+	MinVersion int `json:"minVersion"`
+}
+
+// Error tells the client a message could not be handled
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Seq of the client message that caused the error, if any
+	ReplyTo int `json:"replyTo,omitempty"`
+}
+
+// Round is a single round of a game from the receiving player's point of view
+type Round struct {
+	Number       int    `json:"number"`
+	Move         string `json:"move,omitempty"`
+	OpponentMove string `json:"opponentMove,omitempty"`
+	// win, loss or draw once both players have moved
+	Result string `json:"result,omitempty"`
+}
+
+// Round results
+const (
+	ResultWin  = "win"
+	ResultLoss = "loss"
+	ResultDraw = "draw"
+)
+
+// GameStarted is sent to both players when they are paired for a match. Opponent is empty for a bye.
+type GameStarted struct {
+	Match        int      `json:"match"`
+	Opponent     string   `json:"opponent,omitempty"`
+	Rounds       int      `json:"rounds"`
+	RoundSeconds int      `json:"roundSeconds"`
+	Moves        []string `json:"moves"`
+	SuddenDeath  bool     `json:"suddenDeath"`
+}
+
+// RoundCountdown is sent every second while a round is waiting on moves. Players who have not
+// moved by the deadline forfeit the round, "forfeit" is then shown as their move.
+type RoundCountdown struct {
+	Round       int       `json:"round"`
+	SecondsLeft int       `json:"secondsLeft"`
+	Deadline    time.Time `json:"deadline"`
+}
+
+// GameResumed is sent to a reconnecting player with every round played so far
+type GameResumed struct {
+	Match    int     `json:"match"`
+	Opponent string  `json:"opponent,omitempty"`
+	Rounds   []Round `json:"rounds"`
+}
+
+// MoveAccepted confirms a move while waiting on the opponent
+type MoveAccepted struct {
+	Round int    `json:"round"`
+	Move  string `json:"move"`
+}
+
+// GameResult is the payload of roundFinished, gameWon, gameLost and gameDraw
+type GameResult struct {
+	LastRound Round `json:"lastRound"`
+	Wins      int   `json:"wins"`
+	Losses    int   `json:"losses"`
+	Draws     int   `json:"draws"`
+}
+
+// TournamentEnded announces the winner, empty if nobody won
+type TournamentEnded struct {
+	Winner string `json:"winner"`
+}
+
+// TournamentCancelled is sent when a tournament is called off before it starts
+type TournamentCancelled struct {
+	Reason string `json:"reason"`
+}
+
+// NotEntered is sent to a connected player left out of a tournament when it starts
+type NotEntered struct {
+	Reason string `json:"reason"`
+}
+
+// SpectatedRound is a round both players have committed to
+type SpectatedRound struct {
+	Number      int    `json:"number"`
+	Player1Move string `json:"player1Move"`
+	Player2Move string `json:"player2Move"`
+	// 0 for a draw, otherwise the winning player
+	Winner int `json:"winner"`
+}
+
+// SpectatedGame is a game as seen from the stands. Player2 is empty for a bye.
+type SpectatedGame struct {
+	Match       int              `json:"match"`
+	Player1     string           `json:"player1"`
+	Player2     string           `json:"player2,omitempty"`
+	Rounds      []SpectatedRound `json:"rounds"`
+	Player1Wins int              `json:"player1Wins"`
+	Player2Wins int              `json:"player2Wins"`
+	// Whether each player has committed a move to the round being played
+	Player1Moved bool   `json:"player1Moved"`
+	Player2Moved bool   `json:"player2Moved"`
+	Finished     bool   `json:"finished"`
+	Winner       string `json:"winner,omitempty"`
+}
+
+type Standing struct {
+	Rank     int    `json:"rank"`
+	Username string `json:"username"`
+	Wins     int    `json:"wins"`
+	Losses   int    `json:"losses"`
+	Draws    int    `json:"draws"`
+	Points   int    `json:"points"`
+	Playing  bool   `json:"playing"`
+}
+
+// Segment groups the players of a match, e.g. by score in Swiss or by bracket in double elimination.
+// Games belong to the segment their players are in.
+type Segment struct {
+	Segment int      `json:"segment"`
+	Players []string `json:"players"`
+}
+
+// Snapshot is sent to a spectator on connect, games are keyed by game ID
+type Snapshot struct {
+	Started   bool                     `json:"started"`
+	Match     int                      `json:"match"`
+	Standings []Standing               `json:"standings"`
+	Segments  []Segment                `json:"segments"`
+	Games     map[string]SpectatedGame `json:"games"`
+}
+
+// SpectatedPlayer is the payload of playerJoined and playerLeft
+type SpectatedPlayer struct {
+	Username string `json:"username"`
+}
+
+type TournamentStarted struct {
+	Players []string `json:"players"`
+}
+
+// MoveCommitted says a player has moved without saying what they played
+type MoveCommitted struct {
+	Player string `json:"player"`
+	Round  int    `json:"round"`
+}
+
+type MatchEnded struct {
+	Match int `json:"match"`
+}
+
+// Hello is the client's half of version negotiation
+type Hello struct {
+	Version int `json:"version"`
+}
+
+// Move plays one of the moves listed in GameStarted in the client's current game
+type Move struct {
+	Move string `json:"move"`
+}
+
+// Negotiate picks the version to speak with a client, returning false if the client is too old
+func Negotiate(clientVersion int) (int, bool) {
+	v := min(clientVersion, Version)
+	return v, v >= MinVersion
+}
+
+// NewEnvelope marshals a payload into an envelope
+func NewEnvelope(msgType string, seq, tournamentID int, gameID string, payload any) (Envelope, error) {
+	env := Envelope{
+		Type:       msgType,
+		Seq:        seq,
+		Tournament: tournamentID,
+		Game:       gameID,
+	}
+	if payload == nil {
+		return env, nil
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return env, err
+	}
+	env.Payload = raw
+	return env, nil
+}
@@ -0,0 +1,132 @@
+package ratings
+
+import "math"
+
+// Glicko-2 as described in http://www.glicko.net/glicko/glicko2.pdf, with every game as its own rating period
+const (
+	DefaultRating     = 1500
+	DefaultDeviation  = 350
+	DefaultVolatility = 0.06
+
+	// Constrains how much volatility can change, smaller values change it less
+	tau = 0.5
+	// Converts between the Glicko and Glicko-2 scales
+	scale = 173.7178
+	// Convergence tolerance for the volatility iteration
+	epsilon = 0.000001
+)
+
+// Rating is a player's rating on the familiar Glicko scale
+type Rating struct {
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+}
+
+func Default() Rating {
+	return Rating{Rating: DefaultRating, Deviation: DefaultDeviation, Volatility: DefaultVolatility}
+}
+
+// Scores for a result from the player's point of view
+const (
+	Loss = 0
+	Draw = 0.5
+	Win  = 1
+)
+
+// Result of one game against an opponent, the opponent's rating is from before the game
+type Result struct {
+	Opponent Rating
+	Score    float64
+}
+
+func g(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func expected(mu, muj, phij float64) float64 {
+	return 1 / (1 + math.Exp(-g(phij)*(mu-muj)))
+}
+
+// Update returns the player's rating after the results of a rating period. With no results the
+// deviation grows, a player who has not played for a while is less certain of their rating.
+func Update(r Rating, results []Result) Rating {
+	mu := (r.Rating - DefaultRating) / scale
+	phi := r.Deviation / scale
+	sigma := r.Volatility
+
+	if len(results) == 0 {
+		return Rating{
+			Rating:     r.Rating,
+			Deviation:  math.Min(math.Sqrt(phi*phi+sigma*sigma)*scale, DefaultDeviation),
+			Volatility: sigma,
+		}
+	}
+
+	vInv, sum := 0.0, 0.0
+	for _, res := range results {
+		muj := (res.Opponent.Rating - DefaultRating) / scale
+		phij := res.Opponent.Deviation / scale
+		e := expected(mu, muj, phij)
+		vInv += g(phij) * g(phij) * e * (1 - e)
+		sum += g(phij) * (res.Score - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	sigma = volatility(phi, sigma, v, delta)
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * sum
+
+	return Rating{
+		Rating:     mu*scale + DefaultRating,
+		Deviation:  math.Min(phi*scale, DefaultDeviation),
+		Volatility: sigma,
+	}
+}
+
+// The new volatility, found with the Illinois algorithm from step 5 of the paper
+func volatility(phi, sigma, v, delta float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-d)/(2*d*d) - (x-a)/(tau*tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*tau) < 0 {
+			k++
+		}
+		B = a - k*tau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > epsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
+
+// SeasonReset pulls a rating halfway back to the default and makes it less certain, so the
+// new season sorts players out quickly without starting everyone from scratch
+func SeasonReset(r Rating) Rating {
+	return Rating{
+		Rating:     DefaultRating + (r.Rating-DefaultRating)/2,
+		Deviation:  math.Max(r.Deviation, 200),
+		Volatility: DefaultVolatility,
+	}
+}
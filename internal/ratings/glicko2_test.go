@@ -0,0 +1,71 @@
+package ratings
+
+import (
+	"math"
+	"testing"
+)
+
+func near(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance
+}
+
+// The worked example from section 3 of Glickman's paper
+func TestUpdatePaperExample(t *testing.T) {
+	player := Rating{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	results := []Result{
+		{Opponent: Rating{Rating: 1400, Deviation: 30, Volatility: DefaultVolatility}, Score: Win},
+		{Opponent: Rating{Rating: 1550, Deviation: 100, Volatility: DefaultVolatility}, Score: Loss},
+		{Opponent: Rating{Rating: 1700, Deviation: 300, Volatility: DefaultVolatility}, Score: Loss},
+	}
+
+	got := Update(player, results)
+	if !near(got.Rating, 1464.05, 0.01) {
+		t.Errorf("rating = %.4f, want 1464.05", got.Rating)
+	}
+	if !near(got.Deviation, 151.52, 0.01) {
+		t.Errorf("deviation = %.4f, want 151.52", got.Deviation)
+	}
+	if !near(got.Volatility, 0.05999, 0.00001) {
+		t.Errorf("volatility = %.6f, want 0.05999", got.Volatility)
+	}
+}
+
+func TestUpdateWithoutResults(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Rating
+		want Rating
+	}{
+		{"deviation grows", Rating{Rating: 1650, Deviation: 200, Volatility: 0.06}, Rating{Rating: 1650, Deviation: 200.27, Volatility: 0.06}},
+		{"capped at the default", Default(), Default()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Update(tt.in, nil)
+			if got.Rating != tt.want.Rating || got.Volatility != tt.want.Volatility || !near(got.Deviation, tt.want.Deviation, 0.01) {
+				t.Errorf("Update(%+v, nil) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeasonReset(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Rating
+		want Rating
+	}{
+		{"halfway back and less certain", Rating{Rating: 1900, Deviation: 60, Volatility: 0.09}, Rating{Rating: 1700, Deviation: 200, Volatility: DefaultVolatility}},
+		{"uncertain ratings stay uncertain", Rating{Rating: 1300, Deviation: 280, Volatility: 0.05}, Rating{Rating: 1400, Deviation: 280, Volatility: DefaultVolatility}},
+		{"default is unchanged", Default(), Default()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SeasonReset(tt.in); got != tt.want {
+				t.Errorf("SeasonReset(%+v) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
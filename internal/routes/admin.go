@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"Roshamble/internal/auth"
+	"Roshamble/internal/handlers"
+	"Roshamble/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AdminRoutes(r *gin.Engine, handler *handlers.Handler) {
+	// Moderator tooling, admins get everything moderators do
+	moderator := r.Group("/admin")
+	moderator.Use(auth.JwtAuthMiddleware(services.NewSessionStore(handler.DB)), handler.RefreshClaims, auth.RequireRole(auth.RoleModerator))
+
+	// Only admins hand out roles and manage tournaments
+	admin := moderator.Group("/")
+	admin.Use(auth.RequireRole(auth.RoleAdmin))
+
+	// Live brackets and disputes
+	moderator.GET("/tournaments", handler.AdminTournaments)
+	moderator.GET("/tournaments/:tournamentID", handler.TournamentBracket)
+	moderator.POST("/tournaments/:tournamentID/games/:gameID/resolve", handler.ResolveGame)
+
+	// Tournament management
+	admin.GET("/tournaments/new", handler.NewTournament)
+	admin.POST("/tournaments", handler.CreateTournament)
+	admin.GET("/tournaments/:tournamentID/edit", handler.EditTournament)
+	admin.POST("/tournaments/:tournamentID", handler.UpdateTournament)
+	admin.POST("/tournaments/:tournamentID/clone", handler.CloneTournament)
+	admin.POST("/tournaments/:tournamentID/cancel", handler.CancelTournament)
+
+	// Rating seasons
+	admin.GET("/seasons", handler.AdminSeasons)
+	admin.POST("/seasons", handler.StartSeason)
+
+	// Role management
+	admin.GET("/roles", handler.ListRoles)
+	admin.POST("/roles", handler.GrantRole)
+	// POST rather than DELETE, form bodies are only parsed for POST, PUT and PATCH
+	admin.POST("/roles/revoke", handler.RevokeRole)
+
+	// Invite level grants
+	admin.POST("/invite-levels", handler.GrantInviteLevel)
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"Roshamble/internal/auth"
 	"Roshamble/internal/handlers"
+	"Roshamble/internal/services"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -10,7 +11,7 @@ import (
 
 func ProtectedRoutes(r *gin.Engine, handler *handlers.Handler) {
 	// Protected routes
-	auth := r.Group("/").Use(auth.JwtAuthMiddleware())
+	auth := r.Group("/").Use(auth.JwtAuthMiddleware(services.NewSessionStore(handler.DB)), handler.RefreshClaims)
 
 	auth.GET("/protected", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "You are authenticated"})
@@ -22,13 +23,36 @@ func ProtectedRoutes(r *gin.Engine, handler *handlers.Handler) {
 	// Game handlers
 	auth.GET("/play/:tournamentID", handler.GetPlay)
 	auth.GET("/ws/play/:tournamentID", handler.WsHandler)
+	auth.POST("/join/:tournamentID", handler.JoinTournament)
+	auth.POST("/checkin/:tournamentID", handler.CheckInTournament)
 	auth.POST("/leave/:tournamentID", handler.LeaveTournament)
 
+	// Quickplay matchmaking
+	auth.GET("/queue/:mode", handler.GetQueue)
+	auth.DELETE("/queue/:mode/:id", handler.LeaveQueue)
+	auth.POST("/queuestatus/:mode/:id", handler.QueueStatus)
+	auth.POST("/accept-match/:matchID", handler.AcceptMatch)
+	auth.POST("/decline-match", handler.DeclineMatch)
+	auth.GET("/match/:matchID", handler.GetMatch)
+	auth.GET("/ws/match/:matchID", handler.MatchWsHandler)
+
 	// Profile handlers
 	auth.GET("/profile", handler.GetProfile)
 	auth.PATCH("/profile", handler.UpdateProfile)
+	auth.GET("/stats", handler.GetStats)
+	auth.GET("/stats/:username", handler.GetStats)
+	auth.POST("/sessions/:sessionID/signout", handler.SignOutDevice)
+	auth.POST("/sessions/signout", handler.SignOutEverywhere)
 
 	// Hall of fame handlers
 	auth.GET("/halloffame", handler.GetPastTournaments)
 
+	// Leaderboards and live standings
+	auth.GET("/leaderboard", handler.GetLeaderboard)
+	auth.GET("/standings/:tournamentID", handler.GetStandings)
+
+	// Spectators
+	auth.GET("/spectate/:tournamentID", handler.GetSpectate)
+	auth.GET("/ws/spectate/:tournamentID", handler.SpectateWsHandler)
+
 }
@@ -22,5 +22,11 @@ func PublicRoutes(r *gin.Engine, handler *handlers.Handler) {
 	// Post phone number to trigger otp send
 	r.POST("/auth/otp", handler.TriggerOTP)
 
+	// Delivery receipts from the SMS provider
+	r.POST("/sms/status", handler.SMSStatus)
+
 	r.GET("/redirect", handler.Redirect)
+
+	// Invite links shared from the dashboard
+	r.GET("/ref/:id", handler.Referral)
 }
@@ -0,0 +1,111 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// Monday 7th April 2025, 09:30 UTC
+var monday = time.Date(2025, time.April, 7, 9, 30, 0, 0, time.UTC)
+
+func at(day, hour, minute int) time.Time {
+	return time.Date(2025, time.April, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		after time.Time
+		want  time.Time
+	}{
+		{"daily 12:00 later today", "0 12 * * *", monday, at(7, 12, 0)},
+		{"daily 12:00 tomorrow", "0 12 * * *", at(7, 12, 0), at(8, 12, 0)},
+		{"daily shorthand", "@daily", monday, at(8, 0, 0)},
+		{"weekly on friday", "0 18 * * 5", monday, at(11, 18, 0)},
+		{"weekly shorthand", "@weekly", monday, at(13, 0, 0)},
+		{"sunday as 7", "0 18 * * 7", monday, at(13, 18, 0)},
+		{"every 15 minutes", "*/15 * * * *", monday, at(7, 9, 45)},
+		{"stepped range", "0 8-18/4 * * *", monday, at(7, 12, 0)},
+		{"step from a start", "5/20 * * * *", monday, at(7, 9, 45)},
+		{"list", "0 9,21 * * *", monday, at(7, 21, 0)},
+		{"day of month or week", "0 0 10 * 3", monday, at(9, 0, 0)},
+		{"next month", "0 0 1 * *", monday, time.Date(2025, time.May, 1, 0, 0, 0, 0, time.UTC)},
+		{"never", "0 0 30 2 *", monday, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+			if got := s.Next(tt.after); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"too few fields", "0 12 * *"},
+		{"minute out of range", "60 * * * *"},
+		{"hour out of range", "0 24 * * *"},
+		{"day of month zero", "0 0 0 * *"},
+		{"backwards range", "0 18-8 * * *"},
+		{"not a number", "0 noon * * *"},
+		{"zero step", "*/0 * * * *"},
+		{"unknown shorthand", "@fortnightly"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.expr); err == nil {
+				t.Errorf("Parse(%q) did not fail", tt.expr)
+			}
+		})
+	}
+}
+
+func TestSlots(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		until time.Time
+		want  int
+	}{
+		{"daily over a week", "0 12 * * *", at(14, 9, 30), 7},
+		{"until is inclusive", "0 12 * * *", at(14, 12, 0), 8},
+		{"weekly over a week", "0 18 * * 5", at(14, 9, 30), 1},
+		{"nothing due yet", "0 12 * * *", at(7, 11, 59), 0},
+		{"capped per tick", "* * * * *", at(8, 9, 30), maxSlotsPerTick},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slots, err := Slots(tt.expr, "UTC", monday, tt.until)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(slots) != tt.want {
+				t.Fatalf("got %d slots, want %d", len(slots), tt.want)
+			}
+			for i, slot := range slots {
+				if !slot.After(monday) || slot.After(tt.until) {
+					t.Errorf("slot %v is outside the window", slot)
+				}
+				if i > 0 && !slot.After(slots[i-1]) {
+					t.Errorf("slot %v is not after %v", slot, slots[i-1])
+				}
+			}
+		})
+	}
+
+	if _, err := Slots("0 12 * * *", "Nowhere/Special", monday, at(14, 0, 0)); err == nil {
+		t.Error("unknown timezone did not fail")
+	}
+}
@@ -0,0 +1,117 @@
+package scheduler
+
+import (
+	"Roshamble/internal/services"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+const (
+	// How often templates are filled in and due tournaments are picked up
+	DefaultInterval = 30 * time.Second
+	// Tournaments without a check-in window are still loaded this far ahead of the start
+	// so players can get into the waiting room
+	LoadLead = 5 * time.Minute
+	// Guards against a schedule like "* * * * *" creating a day of tournaments in one go
+	maxSlotsPerTick = 50
+)
+
+// Loader runs a tournament on this server, unless it is already running here or on another replica
+type Loader interface {
+	LoadTournament(t services.Tournament) error
+}
+
+// Scheduler creates tournaments from templates ahead of time and hands them to the loader once they are
+// due, so they start at their start date whether or not anyone has opened the tournament page
+type Scheduler struct {
+	DB       *sql.DB
+	Loader   Loader
+	Interval time.Duration
+}
+
+func New(db *sql.DB, loader Loader) *Scheduler {
+	return &Scheduler{DB: db, Loader: loader, Interval: DefaultInterval}
+}
+
+// Run until the context is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.tick(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick(now time.Time) {
+	s.createUpcoming(now)
+	s.loadDue()
+}
+
+// Make sure every template has a tournament for each of its slots within its create ahead window
+func (s *Scheduler) createUpcoming(now time.Time) {
+	templates, err := services.ActiveTournamentTemplates(s.DB)
+	if err != nil {
+		slog.Error("Error loading tournament templates", "error", err)
+		return
+	}
+
+	for _, tpl := range templates {
+		slots, err := Slots(tpl.Schedule, tpl.Timezone, now, now.Add(tpl.CreateAhead))
+		if err != nil {
+			slog.Error("Error reading tournament template schedule", "templateID", tpl.ID, "error", err)
+			continue
+		}
+		if len(slots) == 0 {
+			continue
+		}
+
+		created, err := services.CreateScheduledTournaments(s.DB, tpl, slots)
+		if err != nil {
+			slog.Error("Error creating scheduled tournaments", "templateID", tpl.ID, "error", err)
+			continue
+		}
+		if created > 0 {
+			slog.Info("Created scheduled tournaments", "templateID", tpl.ID, "template", tpl.Name, "count", created)
+		}
+	}
+}
+
+func (s *Scheduler) loadDue() {
+	due, err := services.DueTournaments(s.DB, LoadLead)
+	if err != nil {
+		slog.Error("Error loading due tournaments", "error", err)
+		return
+	}
+	for _, t := range due {
+		if err := s.Loader.LoadTournament(t); err != nil && !errors.Is(err, services.ErrTournamentOwned) {
+			slog.Error("Error loading scheduled tournament", "tournamentID", t.ID, "error", err)
+		}
+	}
+}
+
+// Slots lists the start times of a schedule after from and up to and including until
+func Slots(expr, timezone string, from, until time.Time) ([]time.Time, error) {
+	schedule, err := Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := []time.Time{}
+	for slot := schedule.Next(from.In(loc)); !slot.IsZero() && !slot.After(until) && len(slots) < maxSlotsPerTick; slot = schedule.Next(slot) {
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"database/sql"
+)
+
+// BracketGame is a game as shown on the admin bracket
+type BracketGame struct {
+	ID          string
+	Player1     string
+	Player2     string
+	Player1Wins int
+	Player2Wins int
+	Winner      string
+	// in_progress, finished, draw or bye
+	Status string
+	// Whether a moderator settled the game by hand
+	Resolved bool
+}
+
+func (g BracketGame) InProgress() bool {
+	return g.Status == "in_progress"
+}
+
+type BracketMatch struct {
+	// Counting from 1
+	Number int
+	Games  []BracketGame
+}
+
+// Every game of a tournament grouped by match, read from the rounds written through as they are played
+func GetBracket(db *sql.DB, tournamentID int) ([]BracketMatch, error) {
+	matches := []BracketMatch{}
+
+	rows, err := db.Query(`SELECT g.id, g.match, g.status, COALESCE(p1.username, ''), COALESCE(p2.username, ''), COALESCE(w.username, ''),
+			COUNT(r.id) FILTER (WHERE r.winner = 1), COUNT(r.id) FILTER (WHERE r.winner = 2),
+			EXISTS (SELECT 1 FROM game_resolutions gr WHERE gr.game_id = g.id)
+		FROM games g
+		LEFT JOIN users p1 ON p1.id = g.player1_id
+		LEFT JOIN users p2 ON p2.id = g.player2_id
+		LEFT JOIN users w ON w.id = g.winner_id
+		LEFT JOIN rounds r ON r.game_id = g.id
+		WHERE g.tournament_id = $1
+		GROUP BY g.id, p1.username, p2.username, w.username
+		ORDER BY g.match, g.created_at`, tournamentID)
+	if err != nil {
+		return matches, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		g := BracketGame{}
+		match := 0
+		if err := rows.Scan(&g.ID, &match, &g.Status, &g.Player1, &g.Player2, &g.Winner, &g.Player1Wins, &g.Player2Wins, &g.Resolved); err != nil {
+			return matches, err
+		}
+		if len(matches) == 0 || matches[len(matches)-1].Number != match+1 {
+			matches = append(matches, BracketMatch{Number: match + 1})
+		}
+		matches[len(matches)-1].Games = append(matches[len(matches)-1].Games, g)
+	}
+
+	return matches, rows.Err()
+}
+
+// Record who settled a disputed game and why
+func RecordGameResolution(db *sql.DB, tournamentID int, gameID, winnerID, resolvedBy, reason string) error {
+	_, err := db.Exec("INSERT INTO game_resolutions (game_id, tournament_id, winner_id, resolved_by, reason) VALUES ($1, $2, NULLIF($3, '')::UUID, $4, $5)",
+		gameID, tournamentID, winnerID, resolvedBy, reason)
+	return err
+}
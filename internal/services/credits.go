@@ -0,0 +1,155 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// House accounts, credits paid by players land in one of these and credits handed out come from them
+const (
+	AccountEntryFees = "entry_fees"
+	AccountGrants    = "grants"
+)
+
+// Transaction kinds, stored in credit_transactions.kind
+const (
+	CreditEntryFee = "entry_fee"
+	CreditRefund   = "refund"
+	CreditGrant    = "grant"
+)
+
+var ErrInsufficientCredits = errors.New("you do not have enough credits")
+
+// Posting moves credits into or out of a single account, the postings of a transaction sum to zero
+type Posting struct {
+	AccountID int
+	Amount    int
+}
+
+// CreditEntry is one line of a player's credit history
+type CreditEntry struct {
+	Amount      int
+	Kind        string
+	Description string
+	CreatedAt   time.Time
+}
+
+func (e CreditEntry) DateString() string {
+	return e.CreatedAt.Format("Jan 2 3:04 PM")
+}
+
+// Record a transaction, returning false without touching any balances if the key has already been used
+func PostTransaction(tx *sql.Tx, key, kind, description string, tournamentID int, postings ...Posting) (bool, error) {
+	sum := 0
+	for _, p := range postings {
+		sum += p.Amount
+	}
+	if sum != 0 {
+		return false, fmt.Errorf("credit transaction %s does not balance, off by %d", key, sum)
+	}
+
+	var txID int
+	err := tx.QueryRow("INSERT INTO credit_transactions (idempotency_key, kind, description, tournament_id) VALUES ($1, $2, $3, NULLIF($4, 0)) ON CONFLICT (idempotency_key) DO NOTHING RETURNING id",
+		key, kind, description, tournamentID).Scan(&txID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	// Lock accounts in a fixed order so concurrent transfers cannot deadlock
+	sorted := append([]Posting(nil), postings...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].AccountID < sorted[j].AccountID })
+	for _, p := range sorted {
+		res, err := tx.Exec("UPDATE credit_accounts SET balance = balance + $1 WHERE id = $2 AND (user_id IS NULL OR balance + $1 >= 0)", p.Amount, p.AccountID)
+		if err != nil {
+			return false, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return false, err
+		} else if n == 0 {
+			return false, ErrInsufficientCredits
+		}
+		if _, err := tx.Exec("INSERT INTO credit_entries (transaction_id, account_id, amount) VALUES ($1, $2, $3)", txID, p.AccountID, p.Amount); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Move credits from one account to another in a single transaction
+func Transfer(tx *sql.Tx, key, kind, description string, tournamentID, from, to, amount int) (bool, error) {
+	return PostTransaction(tx, key, kind, description, tournamentID,
+		Posting{AccountID: from, Amount: -amount},
+		Posting{AccountID: to, Amount: amount},
+	)
+}
+
+// Returns the ID of the player's wallet, opening one if they do not have it yet
+func UserAccount(tx *sql.Tx, userID string) (int, error) {
+	var id int
+	if _, err := tx.Exec("INSERT INTO credit_accounts (user_id) VALUES ($1) ON CONFLICT (user_id) DO NOTHING", userID); err != nil {
+		return id, err
+	}
+	err := tx.QueryRow("SELECT id FROM credit_accounts WHERE user_id = $1", userID).Scan(&id)
+	return id, err
+}
+
+func SystemAccount(tx *sql.Tx, name string) (int, error) {
+	var id int
+	err := tx.QueryRow("SELECT id FROM credit_accounts WHERE name = $1", name).Scan(&id)
+	return id, err
+}
+
+// Hand a player credits from the house
+func GrantCredits(tx *sql.Tx, key, description, userID string, amount int) (bool, error) {
+	from, err := SystemAccount(tx, AccountGrants)
+	if err != nil {
+		return false, err
+	}
+	to, err := UserAccount(tx, userID)
+	if err != nil {
+		return false, err
+	}
+	return Transfer(tx, key, CreditGrant, description, 0, from, to, amount)
+}
+
+func GetCreditBalance(db *sql.DB, userID string) (int, error) {
+	balance := 0
+	err := db.QueryRow("SELECT balance FROM credit_accounts WHERE user_id = $1", userID).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return balance, err
+}
+
+// The player's most recent credit movements, newest first
+func GetCreditHistory(db *sql.DB, userID string, limit int) ([]CreditEntry, error) {
+	history := []CreditEntry{}
+
+	rows, err := db.Query(`SELECT e.amount, t.kind, t.description, t.created_at
+		FROM credit_entries e
+		JOIN credit_accounts a ON a.id = e.account_id
+		JOIN credit_transactions t ON t.id = e.transaction_id
+		WHERE a.user_id = $1
+		ORDER BY t.created_at DESC, t.id DESC
+		LIMIT $2`, userID, limit)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := CreditEntry{}
+		if err := rows.Scan(&e.Amount, &e.Kind, &e.Description, &e.CreatedAt); err != nil {
+			return history, err
+		}
+		history = append(history, e)
+	}
+
+	return history, rows.Err()
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"Roshamble/internal/tournament"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/lib/pq"
+)
+
+// TournamentStore writes tournament state, games and rounds through to postgres as they are played
+type TournamentStore struct {
+	DB *sql.DB
+}
+
+func NewTournamentStore(db *sql.DB) *TournamentStore {
+	return &TournamentStore{DB: db}
+}
+
+func (s *TournamentStore) CreateGame(tournamentID int, game tournament.Game) error {
+	// Byes are settled the moment they are paired
+	status := "in_progress"
+	winnerID := sql.NullString{}
+	if game.Player1 == nil || game.Player2 == nil {
+		status = "bye"
+		winnerID = playerID(game.Player1, game.Player2)
+	}
+
+	_, err := s.DB.Exec("INSERT INTO games (id, tournament_id, match, player1_id, player2_id, winner_id, status, finished_at) VALUES ($1, $2, $3, $4, $5, $6, $7, CASE WHEN $7 = 'bye' THEN NOW() END) ON CONFLICT (id) DO NOTHING",
+		game.ID, tournamentID, game.Match, playerID(game.Player1), playerID(game.Player2), winnerID, status)
+	return err
+}
+
+func (s *TournamentStore) SaveRound(gameID string, number int, round tournament.Round) error {
+	_, err := s.DB.Exec("INSERT INTO rounds (game_id, round_number, player1_move, player2_move, winner) VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5) ON CONFLICT (game_id, round_number) DO UPDATE SET player1_move = EXCLUDED.player1_move, player2_move = EXCLUDED.player2_move, winner = EXCLUDED.winner, updated_at = NOW()",
+		gameID, number, round.Player1Move, round.Player2Move, round.Winner)
+	return err
+}
+
+// Tournament games always count towards ratings
+func (s *TournamentStore) FinishGame(game tournament.Game) error {
+	if err := s.saveResult(game); err != nil {
+		return err
+	}
+	return RateGame(s.DB, game)
+}
+
+func (s *TournamentStore) saveResult(game tournament.Game) error {
+	status := "finished"
+	winnerID := sql.NullString{}
+	switch {
+	case game.Player1 == nil || game.Player2 == nil:
+		status = "bye"
+		winnerID = playerID(game.Player1, game.Player2)
+	case game.WinnerUsername == "":
+		status = "draw"
+	case game.WinnerUsername == game.Player1.Username:
+		winnerID = playerID(game.Player1)
+	default:
+		winnerID = playerID(game.Player2)
+	}
+
+	_, err := s.DB.Exec("UPDATE games SET status = $1, winner_id = $2, finished_at = COALESCE(finished_at, NOW()) WHERE id = $3", status, winnerID, game.ID)
+	return err
+}
+
+// Returns the ID of the first non nil player, or NULL if there is none
+func playerID(players ...*tournament.Player) sql.NullString {
+	for _, p := range players {
+		if p != nil && p.ID != "" {
+			return sql.NullString{String: p.ID, Valid: true}
+		}
+	}
+	return sql.NullString{}
+}
+
+func (s *TournamentStore) StartTournament(tournamentID int) error {
+	return UpdateTournamentStatus(s.DB, tournamentID, TournamentRunning)
+}
+
+func (s *TournamentStore) FinishTournament(tournamentID int, winnerID string) error {
+	res, err := s.DB.Exec("UPDATE tournaments SET status = $1, winner_id = NULLIF($2, '')::UUID WHERE id = $3 AND status = $4", TournamentFinished, winnerID, tournamentID, TournamentRunning)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("tournament %d is not running", tournamentID)
+	}
+
+	// The tournament is over either way, a failed payout can be picked up when the players finish another one
+	if err := RewardReferrals(s.DB, tournamentID); err != nil {
+		slog.Error("Error rewarding referrals", "tournamentID", tournamentID, "error", err)
+	}
+	if err := UpdateTournamentInviteLevels(s.DB, tournamentID); err != nil {
+		slog.Error("Error updating invite levels", "tournamentID", tournamentID, "error", err)
+	}
+	return nil
+}
+
+func (s *TournamentStore) CancelTournament(tournamentID int) error {
+	if err := UpdateTournamentStatus(s.DB, tournamentID, TournamentCancelled); err != nil {
+		return err
+	}
+	return RefundEntryFees(s.DB, tournamentID)
+}
+
+// Load the persisted state of every running tournament so they can be restored after a restart
+func LoadRunningTournaments(db *sql.DB) ([]tournament.Snapshot, error) {
+	snaps := []tournament.Snapshot{}
+
+	rows, err := db.Query("SELECT id, start_date, round_timeout_seconds, format, best_of, sudden_death, move_set FROM tournaments WHERE status = $1", TournamentRunning)
+	if err != nil {
+		return snaps, err
+	}
+	for rows.Next() {
+		snap := tournament.Snapshot{}
+		t := Tournament{}
+		if err := rows.Scan(&snap.ID, &snap.StartDate, &t.RoundTimeout, &t.Format, &t.BestOf, &t.SuddenDeath, &t.MoveSet); err != nil {
+			rows.Close()
+			return snaps, err
+		}
+		t.ID = snap.ID
+		snap.Config = t.GameConfig()
+		snaps = append(snaps, snap)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return snaps, err
+	}
+
+	for i := range snaps {
+		if err := loadTournamentGames(db, &snaps[i]); err != nil {
+			return snaps, fmt.Errorf("loading games for tournament %d: %w", snaps[i].ID, err)
+		}
+	}
+
+	return snaps, nil
+}
+
+func loadTournamentGames(db *sql.DB, snap *tournament.Snapshot) error {
+	rows, err := db.Query(`SELECT g.id, g.match, g.status, p1.id, p1.username, p2.id, p2.username, COALESCE(w.username, '')
+		FROM games g
+		LEFT JOIN users p1 ON p1.id = g.player1_id
+		LEFT JOIN users p2 ON p2.id = g.player2_id
+		LEFT JOIN users w ON w.id = g.winner_id
+		WHERE g.tournament_id = $1
+		ORDER BY g.match, g.created_at`, snap.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	players := map[string]*tournament.Player{}
+	getPlayer := func(id, username sql.NullString) *tournament.Player {
+		if !id.Valid {
+			return nil
+		}
+		if p, ok := players[id.String]; ok {
+			return p
+		}
+		p := &tournament.Player{ID: id.String, Username: username.String}
+		players[id.String] = p
+		snap.Players = append(snap.Players, p)
+		return p
+	}
+
+	type row struct {
+		game   tournament.Game
+		status string
+	}
+	games := []row{}
+	for rows.Next() {
+		r := row{}
+		var p1ID, p1Name, p2ID, p2Name sql.NullString
+		if err := rows.Scan(&r.game.ID, &r.game.Match, &r.status, &p1ID, &p1Name, &p2ID, &p2Name, &r.game.WinnerUsername); err != nil {
+			return err
+		}
+		r.game.Finished = r.status != "in_progress"
+		r.game.Player1 = getPlayer(p1ID, p1Name)
+		r.game.Player2 = getPlayer(p2ID, p2Name)
+		if r.game.Match > snap.CurMatch {
+			snap.CurMatch = r.game.Match
+		}
+		games = append(games, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Records are only updated once a match has been wrapped up
+	gameIDs := []string{}
+	for _, r := range games {
+		if r.game.Match < snap.CurMatch {
+			r.game.RecordResult()
+			continue
+		}
+		r.game.Rounds = make([]tournament.Round, snap.Config.Ruleset.BestOf)
+		snap.Games = append(snap.Games, r.game)
+		gameIDs = append(gameIDs, r.game.ID)
+	}
+
+	return loadRounds(db, snap.Games, gameIDs)
+}
+
+func loadRounds(db *sql.DB, games []tournament.Game, gameIDs []string) error {
+	if len(gameIDs) == 0 {
+		return nil
+	}
+
+	byID := map[string]*tournament.Game{}
+	for i := range games {
+		byID[games[i].ID] = &games[i]
+	}
+
+	rows, err := db.Query("SELECT game_id, round_number, COALESCE(player1_move, ''), COALESCE(player2_move, ''), winner FROM rounds WHERE game_id = ANY($1::UUID[]) ORDER BY game_id, round_number", pq.Array(gameIDs))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var gameID string
+		var number int
+		round := tournament.Round{}
+		if err := rows.Scan(&gameID, &number, &round.Player1Move, &round.Player2Move, &round.Winner); err != nil {
+			return err
+		}
+		game, ok := byID[gameID]
+		if !ok || number < 0 {
+			continue
+		}
+		for len(game.Rounds) <= number {
+			game.Rounds = append(game.Rounds, tournament.Round{})
+		}
+		game.Rounds[number] = round
+	}
+
+	return rows.Err()
+}
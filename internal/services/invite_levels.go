@@ -0,0 +1,163 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+var (
+	ErrInviteLevelTooLow  = errors.New("you need a higher invite level for this tournament")
+	ErrInvalidInviteLevel = errors.New("no such invite level")
+)
+
+// InviteLevelRule promotes a player to Level once they meet any one of the thresholds, zero thresholds are ignored
+type InviteLevelRule struct {
+	Level       int
+	GamesPlayed int
+	Wins        int
+	Referrals   int
+}
+
+// Everyone starts at level 1, rules are in ascending order of level
+var InviteLevelRules = []InviteLevelRule{
+	{Level: 2, GamesPlayed: 5, Referrals: 1},
+	{Level: 3, GamesPlayed: 25, Wins: 10, Referrals: 3},
+	{Level: 4, GamesPlayed: 100, Wins: 40, Referrals: 10},
+	{Level: 5, GamesPlayed: 250, Wins: 100, Referrals: 25},
+}
+
+// PlayerProgress is what counts towards a player's invite level
+type PlayerProgress struct {
+	Level        int
+	GamesPlayed  int
+	Wins         int
+	Referrals    int
+	GrantedLevel int
+}
+
+func (r InviteLevelRule) Met(p PlayerProgress) bool {
+	return (r.GamesPlayed > 0 && p.GamesPlayed >= r.GamesPlayed) ||
+		(r.Wins > 0 && p.Wins >= r.Wins) ||
+		(r.Referrals > 0 && p.Referrals >= r.Referrals)
+}
+
+// Highest level the player has earned or been granted. Levels are never taken away so this is at least their current level.
+func (p PlayerProgress) EarnedLevel() int {
+	level := max(p.Level, p.GrantedLevel, 1)
+	for _, rule := range InviteLevelRules {
+		if rule.Level > level && rule.Met(p) {
+			level = rule.Level
+		}
+	}
+	return level
+}
+
+// The rule for the level after the player's current one, false if they are at the top
+func (p PlayerProgress) NextLevel() (InviteLevelRule, bool) {
+	for _, rule := range InviteLevelRules {
+		if rule.Level > p.Level {
+			return rule, true
+		}
+	}
+	return InviteLevelRule{}, false
+}
+
+func GetPlayerProgress(db *sql.DB, userID string) (PlayerProgress, error) {
+	p := PlayerProgress{}
+	// Only tournament games count, quickplay is too easy to farm with a friend
+	err := db.QueryRow(`SELECT u.invite_level,
+		(SELECT COUNT(*) FROM games g WHERE (g.player1_id = u.id OR g.player2_id = u.id) AND g.status IN ('finished', 'draw') AND g.tournament_id IS NOT NULL),
+		(SELECT COUNT(*) FROM games g WHERE g.winner_id = u.id AND g.status = 'finished' AND g.tournament_id IS NOT NULL),
+		(SELECT COUNT(*) FROM referrals r WHERE r.referrer_id = u.id AND r.status IN ('rewarded', 'capped')),
+		(SELECT COALESCE(MAX(level), 0) FROM invite_level_grants ig WHERE ig.user_id = u.id)
+		FROM users u WHERE u.id = $1`, userID).Scan(&p.Level, &p.GamesPlayed, &p.Wins, &p.Referrals, &p.GrantedLevel)
+	return p, err
+}
+
+// Promote the player if they have earned a new level, returning their level afterwards
+func UpdateInviteLevel(db *sql.DB, userID string) (int, error) {
+	p, err := GetPlayerProgress(db, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	level := p.EarnedLevel()
+	if level == p.Level {
+		return level, nil
+	}
+	// Never lower a level raised by something else in the meantime
+	if _, err := db.Exec("UPDATE users SET invite_level = GREATEST(invite_level, $1) WHERE id = $2", level, userID); err != nil {
+		return p.Level, err
+	}
+	slog.Info("Player promoted", "userID", userID, "from", p.Level, "to", level)
+	return level, nil
+}
+
+// Promote everyone who played in a tournament that just finished
+func UpdateTournamentInviteLevels(db *sql.DB, tournamentID int) error {
+	rows, err := db.Query(`SELECT player1_id FROM games WHERE tournament_id = $1 AND player1_id IS NOT NULL
+		UNION SELECT player2_id FROM games WHERE tournament_id = $1 AND player2_id IS NOT NULL`, tournamentID)
+	if err != nil {
+		return err
+	}
+	players := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		players = append(players, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range players {
+		if _, err := UpdateInviteLevel(db, id); err != nil {
+			return fmt.Errorf("updating invite level of %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// Highest level there is
+func MaxInviteLevel() int {
+	return InviteLevelRules[len(InviteLevelRules)-1].Level
+}
+
+// Grant a player an invite level by hand, grantedBy is empty when the system grants it
+func GrantInviteLevel(db *sql.DB, userID string, level int, grantedBy, reason string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := grantInviteLevel(tx, userID, level, grantedBy, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Record the grant and raise the player to it, as part of a larger transaction
+func grantInviteLevel(tx *sql.Tx, userID string, level int, grantedBy, reason string) error {
+	if level < 1 || level > MaxInviteLevel() {
+		return fmt.Errorf("%w %d", ErrInvalidInviteLevel, level)
+	}
+
+	if _, err := tx.Exec("INSERT INTO invite_level_grants (user_id, level, granted_by, reason) VALUES ($1, $2, NULLIF($3, '')::UUID, $4)", userID, level, grantedBy, reason); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE users SET invite_level = GREATEST(invite_level, $1) WHERE id = $2", level, userID)
+	return err
+}
+
+func GetInviteLevel(db *sql.DB, userID string) (int, error) {
+	level := 0
+	err := db.QueryRow("SELECT invite_level FROM users WHERE id = $1", userID).Scan(&level)
+	return level, err
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Leaderboards and the time windows they can be filtered by
+const (
+	BoardWins    = "wins"
+	BoardTitles  = "titles"
+	BoardWinRate = "winrate"
+	BoardStreak  = "streak"
+	BoardRating  = "rating"
+
+	WindowWeek  = "week"
+	WindowMonth = "month"
+	WindowAll   = "all"
+
+	// Players need this many games in the window to show up on the win rate board
+	MinWinRateGames = 10
+)
+
+var Boards = []string{BoardWins, BoardTitles, BoardWinRate, BoardStreak, BoardRating}
+var Windows = []string{WindowWeek, WindowMonth, WindowAll}
+
+type LeaderboardEntry struct {
+	// Players with the same value share a rank
+	Rank     int
+	UserID   string
+	Username string
+	Value    float64
+	Games    int
+}
+
+// Display formats the value the board is ranked by
+func (e LeaderboardEntry) Display(board string) string {
+	switch board {
+	case BoardWinRate:
+		return fmt.Sprintf("%.0f%%", e.Value*100)
+	default:
+		return fmt.Sprintf("%.0f", e.Value)
+	}
+}
+
+// Start of the window, NULL for all time
+func windowStart(window string) sql.NullTime {
+	switch window {
+	case WindowWeek:
+		return sql.NullTime{Time: time.Now().AddDate(0, 0, -7), Valid: true}
+	case WindowMonth:
+		return sql.NullTime{Time: time.Now().AddDate(0, -1, 0), Valid: true}
+	}
+	return sql.NullTime{}
+}
+
+// Every decided or drawn game once per player in it, byes are left out
+const playerGamesCTE = `WITH player_games AS (
+	SELECT p.user_id, g.winner_id IS NOT DISTINCT FROM p.user_id AS won, g.finished_at
+	FROM games g
+	CROSS JOIN LATERAL (VALUES (g.player1_id), (g.player2_id)) AS p(user_id)
+	WHERE g.status IN ('finished', 'draw') AND p.user_id IS NOT NULL
+		AND ($2::TIMESTAMPTZ IS NULL OR g.finished_at >= $2::TIMESTAMPTZ)
+)`
+
+// Each query takes the limit, the window start and, for win rate, the minimum games.
+// Rows are user ID, username, the value ranked by and the games it is out of.
+var leaderboardQueries = map[string]string{
+	BoardWins: playerGamesCTE + `
+		SELECT pg.user_id, u.username, COUNT(*) FILTER (WHERE won), COUNT(*) FROM player_games pg
+		JOIN users u ON u.id = pg.user_id
+		GROUP BY pg.user_id, u.username HAVING COUNT(*) FILTER (WHERE won) > 0
+		ORDER BY 3 DESC, 4 LIMIT $1`,
+	BoardWinRate: playerGamesCTE + `
+		SELECT pg.user_id, u.username, (COUNT(*) FILTER (WHERE won))::FLOAT / COUNT(*), COUNT(*) FROM player_games pg
+		JOIN users u ON u.id = pg.user_id
+		GROUP BY pg.user_id, u.username HAVING COUNT(*) >= $3
+		ORDER BY 3 DESC, 4 DESC LIMIT $1`,
+	// Wins since the player's last loss or draw
+	BoardStreak: playerGamesCTE + `,
+		streaks AS (
+			SELECT user_id, COUNT(*) FILTER (WHERE NOT won) OVER (PARTITION BY user_id ORDER BY finished_at DESC) AS breaks
+			FROM player_games
+		)
+		SELECT s.user_id, u.username, COUNT(*), COUNT(*) FROM streaks s
+		JOIN users u ON u.id = s.user_id
+		WHERE breaks = 0
+		GROUP BY s.user_id, u.username
+		ORDER BY 3 DESC LIMIT $1`,
+	BoardTitles: `SELECT t.winner_id, u.username, COUNT(*), COUNT(*) FROM tournaments t
+		JOIN users u ON u.id = t.winner_id
+		WHERE $2::TIMESTAMPTZ IS NULL OR t.start_date >= $2::TIMESTAMPTZ
+		GROUP BY t.winner_id, u.username
+		ORDER BY 3 DESC LIMIT $1`,
+	// Ratings are per season, so there is no window
+	BoardRating: `SELECT r.user_id, u.username, r.rating, r.games FROM player_ratings r
+		JOIN seasons s ON s.id = r.season_id AND s.ended_at IS NULL
+		JOIN users u ON u.id = r.user_id
+		WHERE r.games > 0
+		ORDER BY 3 DESC LIMIT $1`,
+}
+
+// GetLeaderboard returns the top players on a board within a time window
+func GetLeaderboard(db *sql.DB, board, window string, limit int) ([]LeaderboardEntry, error) {
+	entries := []LeaderboardEntry{}
+
+	query, ok := leaderboardQueries[board]
+	if !ok {
+		return entries, fmt.Errorf("unknown leaderboard %q", board)
+	}
+	args := []any{limit}
+	switch board {
+	case BoardRating:
+	case BoardWinRate:
+		args = append(args, windowStart(window), MinWinRateGames)
+	default:
+		args = append(args, windowStart(window))
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := LeaderboardEntry{Rank: len(entries) + 1}
+		if err := rows.Scan(&e.UserID, &e.Username, &e.Value, &e.Games); err != nil {
+			return entries, err
+		}
+		// Ties share the rank of the first player on that value
+		if n := len(entries); n > 0 && entries[n-1].Value == e.Value {
+			e.Rank = entries[n-1].Rank
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// How long a code can be used for
+	OTPLifetime = 5 * time.Minute
+	// Wrong guesses a single code survives
+	MaxCodeAttempts = 5
+)
+
+// OTPLimit locks out a phone number or IP address after too many failed verifications within a window
+type OTPLimit struct {
+	Failures int
+	Window   time.Duration
+	Lockout  time.Duration
+}
+
+var (
+	PhoneOTPLimit = OTPLimit{Failures: 5, Window: 15 * time.Minute, Lockout: 15 * time.Minute}
+	// Looser since players can share an address
+	IPOTPLimit = OTPLimit{Failures: 20, Window: 15 * time.Minute, Lockout: 30 * time.Minute}
+)
+
+var ErrInvalidPhone = errors.New("enter a valid phone number, including the country code if outside North America")
+
+// Six random digits
+func GenerateOTP() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// Codes are keyed on the server secret and the phone number so a leaked otp table cannot be brute forced offline
+func HashOTP(phone, code string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("SECRET")))
+	mac.Write([]byte(phone + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func otpMatches(phone, code, hash string) bool {
+	return hmac.Equal([]byte(HashOTP(phone, code)), []byte(hash))
+}
+
+// NormalizePhone turns a phone number as typed into E.164, e.g. "(555) 123-4567" becomes "+15551234567".
+// Numbers without a country code are taken to be North American.
+func NormalizePhone(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	international := strings.HasPrefix(raw, "+")
+
+	digits := strings.Builder{}
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' || r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+	number := digits.String()
+
+	switch {
+	case international:
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	case len(number) == 10:
+		number = "1" + number
+	case len(number) == 11 && number[0] == '1':
+	default:
+		return "", ErrInvalidPhone
+	}
+
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", ErrInvalidPhone
+	}
+	return "+" + number, nil
+}
+
+func phoneAttemptKey(phone string) string { return "phone:" + phone }
+func ipAttemptKey(ip string) string       { return "ip:" + ip }
+
+// Returns how long until the phone number and IP address may try again, zero if neither is locked out
+func OTPLockout(db *sql.DB, phone, ip string) (time.Duration, error) {
+	var wait sql.NullFloat64
+	err := db.QueryRow("SELECT EXTRACT(EPOCH FROM MAX(locked_until) - NOW()) FROM otp_attempts WHERE key IN ($1, $2) AND locked_until > NOW()", phoneAttemptKey(phone), ipAttemptKey(ip)).Scan(&wait)
+	if err != nil || !wait.Valid {
+		return 0, err
+	}
+	return max(time.Duration(wait.Float64*float64(time.Second)), time.Second), nil
+}
+
+// Count a failed verification against the key, locking it out once it passes the limit
+func recordOTPFailure(db *sql.DB, key string, limit OTPLimit) error {
+	_, err := db.Exec(`INSERT INTO otp_attempts (key, failures, window_start) VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN otp_attempts.window_start < NOW() - make_interval(secs => $2) THEN 1 ELSE otp_attempts.failures + 1 END,
+			window_start = CASE WHEN otp_attempts.window_start < NOW() - make_interval(secs => $2) THEN NOW() ELSE otp_attempts.window_start END`,
+		key, limit.Window.Seconds())
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE otp_attempts SET locked_until = NOW() + make_interval(secs => $1), failures = 0, window_start = NOW() WHERE key = $2 AND failures >= $3",
+		limit.Lockout.Seconds(), key, limit.Failures)
+	return err
+}
+
+func recordOTPFailures(db *sql.DB, phone, ip string) error {
+	if err := recordOTPFailure(db, phoneAttemptKey(phone), PhoneOTPLimit); err != nil {
+		return err
+	}
+	return recordOTPFailure(db, ipAttemptKey(ip), IPOTPLimit)
+}
+
+// Check a code, consuming it if it matches. Wrong guesses count against the phone number, the IP address and the code itself.
+func ConsumeOTP(db *sql.DB, phone, code, ip string) (bool, error) {
+	var id int
+	var hash string
+	err := db.QueryRow("SELECT id, code_hash FROM otp WHERE phone = $1 AND created_at > NOW() - make_interval(secs => $2)", phone, OTPLifetime.Seconds()).Scan(&id, &hash)
+	if err == sql.ErrNoRows {
+		return false, recordOTPFailure(db, ipAttemptKey(ip), IPOTPLimit)
+	} else if err != nil {
+		return false, err
+	}
+
+	if !otpMatches(phone, code, hash) {
+		if _, err := db.Exec("UPDATE otp SET attempts = attempts + 1 WHERE id = $1", id); err != nil {
+			return false, err
+		}
+		if _, err := db.Exec("DELETE FROM otp WHERE id = $1 AND attempts >= $2", id, MaxCodeAttempts); err != nil {
+			return false, err
+		}
+		return false, recordOTPFailures(db, phone, ip)
+	}
+
+	// Single use, whoever deletes it first gets to sign in
+	res, err := db.Exec("DELETE FROM otp WHERE id = $1", id)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	_, err = db.Exec("DELETE FROM otp_attempts WHERE key = $1", phoneAttemptKey(phone))
+	return true, err
+}
+
+func lockoutMessage(wait time.Duration) string {
+	minutes := int(wait.Round(time.Minute) / time.Minute)
+	if minutes <= 1 {
+		return "Too many attempts. Please try again in a minute"
+	}
+	return fmt.Sprintf("Too many attempts. Please try again in %d minutes", minutes)
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"Roshamble/internal/tournament"
+	"database/sql"
+	"sort"
+)
+
+// How many opponents the head to head record keeps
+const headToHeadSize = 10
+
+// MoveCount is how often a move was played out of a set of rounds
+type MoveCount struct {
+	Move    string  `json:"move"`
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"`
+}
+
+type HeadToHead struct {
+	OpponentID string `json:"opponentID"`
+	Opponent   string `json:"opponent"`
+	Wins       int    `json:"wins"`
+	Losses     int    `json:"losses"`
+	Draws      int    `json:"draws"`
+}
+
+func (h HeadToHead) Games() int {
+	return h.Wins + h.Losses + h.Draws
+}
+
+// PlayerStats covers every finished game the player has played, tournament and quickplay alike.
+// Byes are not games.
+type PlayerStats struct {
+	Games  int `json:"games"`
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+	Draws  int `json:"draws"`
+
+	Rounds      int `json:"rounds"`
+	RoundWins   int `json:"roundWins"`
+	RoundLosses int `json:"roundLosses"`
+	RoundDraws  int `json:"roundDraws"`
+
+	// Most played first move of a game
+	FavouriteOpening string      `json:"favouriteOpening"`
+	Moves            []MoveCount `json:"moves"`
+	// Moves played in the round straight after winning or losing one
+	AfterWin  []MoveCount `json:"afterWin"`
+	AfterLoss []MoveCount `json:"afterLoss"`
+
+	LongestWinStreak  int `json:"longestWinStreak"`
+	LongestLossStreak int `json:"longestLossStreak"`
+	// Most played opponents first
+	HeadToHead []HeadToHead `json:"headToHead"`
+}
+
+func percent(n, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(of)
+}
+
+// Rates are percentages
+func (s PlayerStats) WinRate() float64       { return percent(s.Wins, s.Games) }
+func (s PlayerStats) LossRate() float64      { return percent(s.Losses, s.Games) }
+func (s PlayerStats) DrawRate() float64      { return percent(s.Draws, s.Games) }
+func (s PlayerStats) RoundWinRate() float64  { return percent(s.RoundWins, s.Rounds) }
+func (s PlayerStats) RoundLossRate() float64 { return percent(s.RoundLosses, s.Rounds) }
+func (s PlayerStats) RoundDrawRate() float64 { return percent(s.RoundDraws, s.Rounds) }
+
+// Most played first, ties in alphabetical order
+func moveCounts(counts map[string]int) []MoveCount {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	mc := make([]MoveCount, 0, len(counts))
+	for move, n := range counts {
+		mc = append(mc, MoveCount{Move: move, Count: n, Percent: percent(n, total)})
+	}
+	sort.Slice(mc, func(i, j int) bool {
+		if mc[i].Count != mc[j].Count {
+			return mc[i].Count > mc[j].Count
+		}
+		return mc[i].Move < mc[j].Move
+	})
+	return mc
+}
+
+func UserIDByUsername(db *sql.DB, username string) (string, error) {
+	id := ""
+	err := db.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&id)
+	return id, err
+}
+
+func GetPlayerStats(db *sql.DB, userID string) (PlayerStats, error) {
+	s := PlayerStats{}
+	if err := gameStats(db, userID, &s); err != nil {
+		return s, err
+	}
+	return s, roundStats(db, userID, &s)
+}
+
+// Results, streaks and head to head records, oldest game first
+func gameStats(db *sql.DB, userID string, s *PlayerStats) error {
+	rows, err := db.Query(`SELECT opp.id, COALESCE(u.username, ''), g.status, g.winner_id IS NOT DISTINCT FROM $1::UUID
+		FROM games g
+		CROSS JOIN LATERAL (SELECT CASE WHEN g.player1_id = $1 THEN g.player2_id ELSE g.player1_id END AS id) opp
+		LEFT JOIN users u ON u.id = opp.id
+		WHERE $1 IN (g.player1_id, g.player2_id) AND g.status IN ('finished', 'draw')
+		ORDER BY g.finished_at, g.id`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	h2h := map[string]*HeadToHead{}
+	winStreak, lossStreak := 0, 0
+	for rows.Next() {
+		oppID := sql.NullString{}
+		opponent, status, won := "", "", false
+		if err := rows.Scan(&oppID, &opponent, &status, &won); err != nil {
+			return err
+		}
+
+		record, ok := h2h[oppID.String]
+		if !ok {
+			record = &HeadToHead{OpponentID: oppID.String, Opponent: opponent}
+			h2h[oppID.String] = record
+		}
+
+		s.Games++
+		switch {
+		case status == "draw":
+			s.Draws++
+			record.Draws++
+			winStreak, lossStreak = 0, 0
+		case won:
+			s.Wins++
+			record.Wins++
+			winStreak, lossStreak = winStreak+1, 0
+		default:
+			s.Losses++
+			record.Losses++
+			winStreak, lossStreak = 0, lossStreak+1
+		}
+		s.LongestWinStreak = max(s.LongestWinStreak, winStreak)
+		s.LongestLossStreak = max(s.LongestLossStreak, lossStreak)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Opponents who have since deleted their account are not worth a record
+	delete(h2h, "")
+	s.HeadToHead = make([]HeadToHead, 0, len(h2h))
+	for _, record := range h2h {
+		s.HeadToHead = append(s.HeadToHead, *record)
+	}
+	sort.Slice(s.HeadToHead, func(i, j int) bool {
+		a, b := s.HeadToHead[i], s.HeadToHead[j]
+		if a.Games() != b.Games() {
+			return a.Games() > b.Games()
+		}
+		return a.Opponent < b.Opponent
+	})
+	if len(s.HeadToHead) > headToHeadSize {
+		s.HeadToHead = s.HeadToHead[:headToHeadSize]
+	}
+	return nil
+}
+
+// Round results and move tendencies, every round in play order
+func roundStats(db *sql.DB, userID string, s *PlayerStats) error {
+	rows, err := db.Query(`SELECT r.game_id,
+			COALESCE(CASE WHEN g.player1_id = $1 THEN r.player1_move ELSE r.player2_move END, ''),
+			CASE WHEN r.winner = 0 THEN 0 WHEN (r.winner = 1) = (g.player1_id = $1) THEN 1 ELSE -1 END
+		FROM rounds r
+		JOIN games g ON g.id = r.game_id
+		WHERE $1 IN (g.player1_id, g.player2_id) AND g.status IN ('finished', 'draw')
+		ORDER BY g.finished_at, g.id, r.round_number`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	moves, openings, afterWin, afterLoss := map[string]int{}, map[string]int{}, map[string]int{}, map[string]int{}
+	lastGame, lastOutcome := "", 0
+	for rows.Next() {
+		gameID, move, outcome := "", "", 0
+		if err := rows.Scan(&gameID, &move, &outcome); err != nil {
+			return err
+		}
+
+		s.Rounds++
+		switch outcome {
+		case 1:
+			s.RoundWins++
+		case -1:
+			s.RoundLosses++
+		default:
+			s.RoundDraws++
+		}
+
+		// Rounds the player timed out of are recorded as a forfeit, and a half played round may
+		// have no move at all, neither says anything about how they play
+		if move != "" && move != tournament.Forfeit {
+			moves[move]++
+			switch {
+			case gameID != lastGame:
+				openings[move]++
+			case lastOutcome == 1:
+				afterWin[move]++
+			case lastOutcome == -1:
+				afterLoss[move]++
+			}
+		}
+		lastGame, lastOutcome = gameID, outcome
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.Moves = moveCounts(moves)
+	s.AfterWin = moveCounts(afterWin)
+	s.AfterLoss = moveCounts(afterLoss)
+	if opening := moveCounts(openings); len(opening) > 0 {
+		s.FavouriteOpening = opening[0].Move
+	}
+	return nil
+}
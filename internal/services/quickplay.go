@@ -0,0 +1,101 @@
+package services
+
+import (
+	"Roshamble/internal/matchmaking"
+	"Roshamble/internal/tournament"
+	"database/sql"
+	"fmt"
+)
+
+// Quickplay games are decided in a single best of three, replayed if it is drawn
+func QuickplayConfig() tournament.Config {
+	return tournament.Config{
+		Format: tournament.Elimination{Lives: 1},
+		Ruleset: tournament.Ruleset{
+			BestOf:      3,
+			SuddenDeath: true,
+			MoveSet:     tournament.RockPaperScissors,
+		},
+	}
+}
+
+// Record a match both players accepted, returning its ID
+func CreateQuickplayMatch(db *sql.DB, m matchmaking.Match) (int, error) {
+	id := 0
+	err := db.QueryRow("INSERT INTO quickplay_matches (mode, ranked, player1_id, player2_id) VALUES ($1, $2, $3, $4) RETURNING id",
+		m.Mode, m.Mode == matchmaking.ModeRanked, m.Player1.ID, m.Player2.ID).Scan(&id)
+	return id, err
+}
+
+// QuickplayStore runs a quickplay match through the tournament loop as a two player tournament,
+// writing its games to the match instead of a tournament
+type QuickplayStore struct {
+	*TournamentStore
+	// Whether the match's games count towards ratings
+	Ranked bool
+}
+
+func NewQuickplayStore(db *sql.DB, ranked bool) *QuickplayStore {
+	return &QuickplayStore{TournamentStore: NewTournamentStore(db), Ranked: ranked}
+}
+
+func (s *QuickplayStore) CreateGame(matchID int, game tournament.Game) error {
+	_, err := s.DB.Exec("INSERT INTO games (id, quickplay_match_id, match, player1_id, player2_id) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (id) DO NOTHING",
+		game.ID, matchID, game.Match, playerID(game.Player1), playerID(game.Player2))
+	return err
+}
+
+func (s *QuickplayStore) FinishGame(game tournament.Game) error {
+	if s.Ranked {
+		return s.TournamentStore.FinishGame(game)
+	}
+	return s.saveResult(game)
+}
+
+func (s *QuickplayStore) StartTournament(matchID int) error {
+	return nil
+}
+
+func (s *QuickplayStore) FinishTournament(matchID int, winnerID string) error {
+	_, err := s.DB.Exec("UPDATE quickplay_matches SET status = 'finished', winner_id = NULLIF($1, '')::UUID, finished_at = NOW() WHERE id = $2 AND status = 'running'", winnerID, matchID)
+	return err
+}
+
+func (s *QuickplayStore) CancelTournament(matchID int) error {
+	_, err := s.DB.Exec("UPDATE quickplay_matches SET status = 'cancelled', finished_at = NOW() WHERE id = $1 AND status = 'running'", matchID)
+	return err
+}
+
+// Both players are in from the start, whether or not they have connected yet
+func (s *QuickplayStore) ConfirmedPlayers(matchID int) ([]*tournament.Player, error) {
+	players := []*tournament.Player{}
+	rows, err := s.DB.Query(`SELECT u.id, u.username FROM quickplay_matches m
+		JOIN users u ON u.id IN (m.player1_id, m.player2_id)
+		WHERE m.id = $1`, matchID)
+	if err != nil {
+		return players, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := &tournament.Player{}
+		if err := rows.Scan(&p.ID, &p.Username); err != nil {
+			return players, err
+		}
+		players = append(players, p)
+	}
+	if err := rows.Err(); err != nil {
+		return players, err
+	}
+	if len(players) != 2 {
+		return players, fmt.Errorf("quickplay match %d has %d players", matchID, len(players))
+	}
+	return players, nil
+}
+
+// Whether the player is one of the two in the match
+func InQuickplayMatch(db *sql.DB, matchID int, userID string) (bool, error) {
+	in := false
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM quickplay_matches WHERE id = $1 AND $2 IN (player1_id, player2_id))", matchID, userID).Scan(&in)
+	return in, err
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"Roshamble/internal/ratings"
+	"Roshamble/internal/tournament"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type Season struct {
+	ID        int
+	Name      string
+	StartedAt time.Time
+	// Zero while the season is running
+	EndedAt time.Time
+}
+
+func (s Season) Running() bool {
+	return s.EndedAt.IsZero()
+}
+
+func scanSeason(row interface{ Scan(...any) error }) (Season, error) {
+	s := Season{}
+	ended := sql.NullTime{}
+	err := row.Scan(&s.ID, &s.Name, &s.StartedAt, &ended)
+	s.EndedAt = ended.Time
+	return s, err
+}
+
+func CurrentSeason(db *sql.DB) (Season, error) {
+	return scanSeason(db.QueryRow("SELECT id, name, started_at, ended_at FROM seasons WHERE ended_at IS NULL"))
+}
+
+func ListSeasons(db *sql.DB) ([]Season, error) {
+	seasons := []Season{}
+
+	rows, err := db.Query("SELECT id, name, started_at, ended_at FROM seasons ORDER BY id DESC")
+	if err != nil {
+		return seasons, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanSeason(rows)
+		if err != nil {
+			return seasons, err
+		}
+		seasons = append(seasons, s)
+	}
+
+	return seasons, rows.Err()
+}
+
+// End the current season and start the next one. Ratings carry over softened the first time
+// each player is rated in the new season.
+func StartSeason(db *sql.DB, name string) (Season, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Season{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE seasons SET ended_at = NOW() WHERE ended_at IS NULL"); err != nil {
+		return Season{}, err
+	}
+	s, err := scanSeason(tx.QueryRow("INSERT INTO seasons (name) VALUES ($1) RETURNING id, name, started_at, ended_at", name))
+	if err != nil {
+		return s, err
+	}
+
+	return s, tx.Commit()
+}
+
+// *sql.DB or *sql.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// The rating a player starts the season with, carried over from the last season they played in
+func seasonStartRating(q rowQuerier, userID string, seasonID int) (ratings.Rating, error) {
+	r := ratings.Rating{}
+	err := q.QueryRow("SELECT rating, deviation, volatility FROM player_ratings WHERE user_id = $1 AND season_id < $2 ORDER BY season_id DESC LIMIT 1",
+		userID, seasonID).Scan(&r.Rating, &r.Deviation, &r.Volatility)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ratings.Default(), nil
+	}
+	if err != nil {
+		return r, err
+	}
+	return ratings.SeasonReset(r), nil
+}
+
+// The player's rating this season, whether or not they have played in it yet
+func GetRating(db *sql.DB, userID string) (ratings.Rating, error) {
+	r := ratings.Rating{}
+	err := db.QueryRow(`SELECT r.rating, r.deviation, r.volatility FROM player_ratings r
+		JOIN seasons s ON s.id = r.season_id AND s.ended_at IS NULL
+		WHERE r.user_id = $1`, userID).Scan(&r.Rating, &r.Deviation, &r.Volatility)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return r, err
+	}
+
+	season, err := CurrentSeason(db)
+	if err != nil {
+		return ratings.Default(), err
+	}
+	return seasonStartRating(db, userID, season.ID)
+}
+
+// Lock the player's rating for the season, creating it if this is their first game
+func lockRating(tx *sql.Tx, userID string, seasonID int) (ratings.Rating, error) {
+	start, err := seasonStartRating(tx, userID, seasonID)
+	if err != nil {
+		return start, err
+	}
+	if _, err := tx.Exec("INSERT INTO player_ratings (user_id, season_id, rating, deviation, volatility) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING",
+		userID, seasonID, start.Rating, start.Deviation, start.Volatility); err != nil {
+		return start, err
+	}
+
+	r := ratings.Rating{}
+	err = tx.QueryRow("SELECT rating, deviation, volatility FROM player_ratings WHERE user_id = $1 AND season_id = $2 FOR UPDATE",
+		userID, seasonID).Scan(&r.Rating, &r.Deviation, &r.Volatility)
+	return r, err
+}
+
+// RateGame updates both players' ratings for the current season from a finished game.
+// Byes are not rated and games that have already been rated are left alone.
+func RateGame(db *sql.DB, game tournament.Game) error {
+	if game.Player1 == nil || game.Player2 == nil || game.Player1.ID == "" || game.Player2.ID == "" {
+		return nil
+	}
+
+	score := ratings.Draw
+	switch game.WinnerUsername {
+	case "":
+	case game.Player1.Username:
+		score = ratings.Win
+	default:
+		score = ratings.Loss
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	seasonID := 0
+	if err := tx.QueryRow("SELECT id FROM seasons WHERE ended_at IS NULL").Scan(&seasonID); err != nil {
+		return err
+	}
+
+	// Always lock in the same order so two games finishing at once cannot deadlock
+	ids := []string{game.Player1.ID, game.Player2.ID}
+	if ids[1] < ids[0] {
+		ids[0], ids[1] = ids[1], ids[0]
+	}
+	before := map[string]ratings.Rating{}
+	for _, id := range ids {
+		r, err := lockRating(tx, id, seasonID)
+		if err != nil {
+			return err
+		}
+		before[id] = r
+	}
+
+	rated := false
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM rating_history WHERE game_id = $1)", game.ID).Scan(&rated); err != nil {
+		return err
+	}
+	if rated {
+		return nil
+	}
+
+	p1, p2 := before[game.Player1.ID], before[game.Player2.ID]
+	after := map[string]ratings.Rating{
+		game.Player1.ID: ratings.Update(p1, []ratings.Result{{Opponent: p2, Score: score}}),
+		game.Player2.ID: ratings.Update(p2, []ratings.Result{{Opponent: p1, Score: 1 - score}}),
+	}
+	for id, r := range after {
+		if _, err := tx.Exec("UPDATE player_ratings SET rating = $1, deviation = $2, volatility = $3, games = games + 1, updated_at = NOW() WHERE user_id = $4 AND season_id = $5",
+			r.Rating, r.Deviation, r.Volatility, id, seasonID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO rating_history (user_id, season_id, game_id, rating_before, rating_after, deviation, volatility) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			id, seasonID, game.ID, before[id].Rating, r.Rating, r.Deviation, r.Volatility); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Cookie holding the ID of whoever invited the visitor, kept until they sign up
+const ReferralCookie = "ref"
+
+// Referral status, stored in referrals.status
+const (
+	ReferralPending  = "pending"
+	ReferralRewarded = "rewarded"
+	ReferralCapped   = "capped"
+)
+
+// ReferralRewards is what a referrer earns once a player they invited finishes their first tournament
+type ReferralRewards struct {
+	// Credits for the referrer
+	Credits int
+	// Credits for the player who was invited
+	ReferredCredits int
+	// Invite levels the referrer goes up by
+	InviteLevels int
+	// Rewards a referrer can earn in 30 days, further referrals are recorded but not paid out
+	MaxPerMonth int
+}
+
+// Rewards are set with the REFERRAL_CREDITS, REFERRAL_REFERRED_CREDITS, REFERRAL_INVITE_LEVELS and
+// REFERRAL_MAX_PER_MONTH environment variables
+func GetReferralRewards() ReferralRewards {
+	return ReferralRewards{
+		Credits:         envInt("REFERRAL_CREDITS", 5),
+		ReferredCredits: envInt("REFERRAL_REFERRED_CREDITS", 0),
+		InviteLevels:    envInt("REFERRAL_INVITE_LEVELS", 0),
+		MaxPerMonth:     envInt("REFERRAL_MAX_PER_MONTH", 20),
+	}
+}
+
+func envInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Error("Invalid integer in environment, using default", "key", key, "value", v, "default", fallback)
+		return fallback
+	}
+	return n
+}
+
+// Returns true if the link points at an existing player
+func ValidReferrer(db *sql.DB, referrerID string) bool {
+	if _, err := uuid.Parse(referrerID); err != nil {
+		return false
+	}
+	exists := false
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", referrerID).Scan(&exists); err != nil {
+		slog.Error("Error checking referrer", "error", err.Error())
+		return false
+	}
+	return exists
+}
+
+// Credit the player named in the referral cookie with signing up a new user. Players cannot refer
+// themselves and each phone number can only be referred once.
+func RecordReferral(c *gin.Context, db *sql.DB, userID, phone string) {
+	referrerID, err := c.Cookie(ReferralCookie)
+	if err != nil || referrerID == "" || referrerID == userID || !ValidReferrer(db, referrerID) {
+		return
+	}
+
+	res, err := db.Exec(`INSERT INTO referrals (referrer_id, referred_id, phone)
+		SELECT $1, $2, $3 WHERE NOT EXISTS (SELECT 1 FROM users WHERE id = $1 AND phone = $3)
+		ON CONFLICT DO NOTHING`, referrerID, userID, phone)
+	if err != nil {
+		slog.Error("Error recording referral", "error", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.Info("Referral recorded", "referrerID", referrerID, "referredID", userID)
+	}
+}
+
+// Pay out referrals for players who just finished their first tournament
+func RewardReferrals(db *sql.DB, tournamentID int) error {
+	rewards := GetReferralRewards()
+
+	rows, err := db.Query(`SELECT r.id, r.referrer_id, r.referred_id
+		FROM referrals r
+		WHERE r.status = $1 AND r.referred_id IN (
+			SELECT player1_id FROM games WHERE tournament_id = $2 AND player1_id IS NOT NULL
+			UNION SELECT player2_id FROM games WHERE tournament_id = $2 AND player2_id IS NOT NULL
+		)`, ReferralPending, tournamentID)
+	if err != nil {
+		return err
+	}
+	type referral struct {
+		id         int
+		referrerID string
+		referredID string
+	}
+	due := []referral{}
+	for rows.Next() {
+		r := referral{}
+		if err := rows.Scan(&r.id, &r.referrerID, &r.referredID); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range due {
+		if err := rewardReferral(db, rewards, r.id, r.referrerID, r.referredID); err != nil {
+			return fmt.Errorf("rewarding referral %d: %w", r.id, err)
+		}
+		// Referrals count towards the referrer's invite level
+		if _, err := UpdateInviteLevel(db, r.referrerID); err != nil {
+			return fmt.Errorf("updating invite level of %s: %w", r.referrerID, err)
+		}
+	}
+	return nil
+}
+
+func rewardReferral(db *sql.DB, rewards ReferralRewards, id int, referrerID, referredID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialise rewards per referrer so the monthly limit holds
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", "referrals:"+referrerID); err != nil {
+		return err
+	}
+
+	paid := 0
+	if err := tx.QueryRow("SELECT COUNT(*) FROM referrals WHERE referrer_id = $1 AND status = $2 AND rewarded_at > NOW() - INTERVAL '30 days'", referrerID, ReferralRewarded).Scan(&paid); err != nil {
+		return err
+	}
+
+	status := ReferralRewarded
+	if paid >= rewards.MaxPerMonth {
+		status = ReferralCapped
+	}
+	res, err := tx.Exec("UPDATE referrals SET status = $1, rewarded_at = NOW() WHERE id = $2 AND status = $3", status, id, ReferralPending)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		// Someone else got here first
+		return err
+	}
+
+	if status == ReferralRewarded {
+		key := fmt.Sprintf("referral:%d", id)
+		if rewards.Credits > 0 {
+			if _, err := GrantCredits(tx, key, "Friend finished their first tournament", referrerID, rewards.Credits); err != nil {
+				return err
+			}
+		}
+		if rewards.ReferredCredits > 0 {
+			if _, err := GrantCredits(tx, key+":referred", "Finished your first tournament", referredID, rewards.ReferredCredits); err != nil {
+				return err
+			}
+		}
+		if rewards.InviteLevels > 0 {
+			// Through the grants so the promotion shows up alongside any given by hand, never past the top level
+			current, level := 0, 0
+			if err := tx.QueryRow("SELECT invite_level, LEAST(invite_level + $1, $2) FROM users WHERE id = $3 FOR UPDATE", rewards.InviteLevels, MaxInviteLevel(), referrerID).Scan(&current, &level); err != nil {
+				return err
+			}
+			if level > current {
+				if err := grantInviteLevel(tx, referrerID, level, "", fmt.Sprintf("Referral %d rewarded", id)); err != nil {
+					return err
+				}
+			}
+		}
+		slog.Info("Referral rewarded", "referralID", id, "referrerID", referrerID)
+	} else {
+		slog.Warn("Referrer hit the monthly referral limit", "referralID", id, "referrerID", referrerID)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"Roshamble/internal/tournament"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Registration status, stored in tournament_players.status
+const (
+	RegistrationRegistered = "registered"
+	RegistrationCheckedIn  = "checked_in"
+)
+
+var (
+	ErrRegistrationClosed = errors.New("registration for this tournament is closed")
+	ErrTournamentFull     = errors.New("this tournament is full")
+	ErrTournamentStarted  = errors.New("this tournament has already started")
+	ErrNotRegistered      = errors.New("you are not registered for this tournament")
+	ErrCheckInClosed      = errors.New("check-in for this tournament is not open")
+)
+
+type Registration struct {
+	TournamentID int
+	PlayerID     string
+	Status       string
+}
+
+// Players have to check in when the tournament asks for it, otherwise registering is enough
+func (r Registration) Confirmed(t Tournament) bool {
+	return r.Status == RegistrationCheckedIn || (r.Status == RegistrationRegistered && t.CheckInMinutes == 0)
+}
+
+// When players can start checking in
+func (t *Tournament) CheckInOpensAt() time.Time {
+	return t.StartTime().Add(-time.Duration(t.CheckInMinutes) * time.Minute)
+}
+
+// Returns the player's registration for the tournament, Status is empty if they have not registered
+func GetRegistration(db *sql.DB, tournamentID int, playerID string) (Registration, error) {
+	r := Registration{TournamentID: tournamentID, PlayerID: playerID}
+	err := db.QueryRow("SELECT status FROM tournament_players WHERE tournament_id = $1 AND player_id = $2", tournamentID, playerID).Scan(&r.Status)
+	if err == sql.ErrNoRows {
+		return r, nil
+	}
+	return r, err
+}
+
+func CountRegistrations(db *sql.DB, tournamentID int) (int, error) {
+	n := 0
+	err := db.QueryRow("SELECT COUNT(*) FROM tournament_players WHERE tournament_id = $1", tournamentID).Scan(&n)
+	return n, err
+}
+
+func AddTournamentPlayer(c *gin.Context, db *sql.DB, tournamentID int, claims Claims) error {
+	tx, err := db.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the tournament row so concurrent registrations cannot overfill it
+	var open, allowed bool
+	var maxPlayers sql.NullInt64
+	var name string
+	var fee int
+	err = tx.QueryRow("SELECT status IN ('scheduled', 'open') AND NOW() < COALESCE(registration_closes_at, start_date), COALESCE(invite_level, 0) <= (SELECT invite_level FROM users WHERE id = $2), max_players, COALESCE(name, ''), entry_fee FROM tournaments WHERE id = $1 FOR UPDATE", tournamentID, claims.ID).Scan(&open, &allowed, &maxPlayers, &name, &fee)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("tournament %d not found", tournamentID)
+	} else if err != nil {
+		slog.Error("Error loading tournament for registration", "error", err.Error())
+		return err
+	}
+	if !open {
+		return ErrRegistrationClosed
+	}
+	if !allowed {
+		return ErrInviteLevelTooLow
+	}
+
+	if maxPlayers.Valid {
+		others := 0
+		if err := tx.QueryRow("SELECT COUNT(*) FROM tournament_players WHERE tournament_id = $1 AND player_id <> $2", tournamentID, claims.ID).Scan(&others); err != nil {
+			return err
+		}
+		if int64(others) >= maxPlayers.Int64 {
+			return ErrTournamentFull
+		}
+	}
+
+	var registeredAt time.Time
+	err = tx.QueryRow("INSERT INTO tournament_players (tournament_id, player_id, entry_fee) VALUES ($1, $2, $3) ON CONFLICT (tournament_id, player_id) DO NOTHING RETURNING registered_at", tournamentID, claims.ID, fee).Scan(&registeredAt)
+	if err == sql.ErrNoRows {
+		// Already registered, and already charged
+		return nil
+	} else if err != nil {
+		slog.Error("Error adding player to tournament", "error", err.Error())
+		return err
+	}
+
+	if fee > 0 {
+		from, err := UserAccount(tx, claims.ID)
+		if err != nil {
+			return err
+		}
+		to, err := SystemAccount(tx, AccountEntryFees)
+		if err != nil {
+			return err
+		}
+		if _, err := Transfer(tx, entryFeeKey(CreditEntryFee, tournamentID, claims.ID, registeredAt), CreditEntryFee, "Entry to "+name, tournamentID, from, to, fee); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func RemoveTournamentPlayer(c *gin.Context, db *sql.DB, tournamentID int, claims Claims) error {
+	tx, err := db.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var fee int
+	var registeredAt time.Time
+	err = tx.QueryRow("DELETE FROM tournament_players tp USING tournaments t WHERE t.id = tp.tournament_id AND tp.tournament_id = $1 AND tp.player_id = $2 AND t.status IN ('scheduled', 'open') RETURNING tp.entry_fee, tp.registered_at", tournamentID, claims.ID).Scan(&fee, &registeredAt)
+	if err == sql.ErrNoRows {
+		return registrationUnchanged(db, tournamentID, claims.ID, ErrTournamentStarted)
+	} else if err != nil {
+		slog.Error("Error removing player from tournament", "error", err.Error())
+		return err
+	}
+
+	if err := refundEntryFee(tx, tournamentID, claims.ID, fee, registeredAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Confirm a registration, check-in is open for CheckInMinutes before the tournament starts
+func CheckInTournamentPlayer(c *gin.Context, db *sql.DB, tournamentID int, claims Claims) error {
+	res, err := db.Exec(`UPDATE tournament_players tp SET status = $3, checked_in_at = COALESCE(checked_in_at, NOW())
+		FROM tournaments t
+		WHERE t.id = tp.tournament_id AND tp.tournament_id = $1 AND tp.player_id = $2
+		AND t.status IN ('scheduled', 'open') AND NOW() >= t.start_date - make_interval(mins => t.check_in_minutes)`,
+		tournamentID, claims.ID, RegistrationCheckedIn)
+	if err != nil {
+		slog.Error("Error checking player in to tournament", "error", err.Error())
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return registrationUnchanged(db, tournamentID, claims.ID, ErrCheckInClosed)
+	}
+	return nil
+}
+
+// Works out why a registration was left untouched, either the player never registered or the tournament is past the point of allowing it
+func registrationUnchanged(db *sql.DB, tournamentID int, playerID string, closed error) error {
+	r, err := GetRegistration(db, tournamentID, playerID)
+	if err != nil {
+		return err
+	}
+	if r.Status == "" {
+		return ErrNotRegistered
+	}
+	return closed
+}
+
+func (s *TournamentStore) ConfirmedPlayers(tournamentID int) ([]*tournament.Player, error) {
+	players := []*tournament.Player{}
+
+	rows, err := s.DB.Query(`SELECT u.id, u.username
+		FROM tournament_players tp
+		JOIN tournaments t ON t.id = tp.tournament_id
+		JOIN users u ON u.id = tp.player_id
+		WHERE tp.tournament_id = $1 AND (tp.status = $2 OR t.check_in_minutes = 0)
+		ORDER BY tp.registered_at`, tournamentID, RegistrationCheckedIn)
+	if err != nil {
+		return players, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := &tournament.Player{}
+		if err := rows.Scan(&p.ID, &p.Username); err != nil {
+			return players, err
+		}
+		players = append(players, p)
+	}
+
+	return players, rows.Err()
+}
+
+// Pay back every entry fee of a cancelled tournament. Refunds are keyed on the registration so this can safely be retried.
+func RefundEntryFees(db *sql.DB, tournamentID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT player_id, entry_fee, registered_at FROM tournament_players WHERE tournament_id = $1 AND entry_fee > 0", tournamentID)
+	if err != nil {
+		return err
+	}
+	type paid struct {
+		playerID     string
+		fee          int
+		registeredAt time.Time
+	}
+	registrations := []paid{}
+	for rows.Next() {
+		p := paid{}
+		if err := rows.Scan(&p.playerID, &p.fee, &p.registeredAt); err != nil {
+			rows.Close()
+			return err
+		}
+		registrations = append(registrations, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range registrations {
+		if err := refundEntryFee(tx, tournamentID, p.playerID, p.fee, p.registeredAt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func refundEntryFee(tx *sql.Tx, tournamentID int, playerID string, fee int, registeredAt time.Time) error {
+	if fee <= 0 {
+		return nil
+	}
+	from, err := SystemAccount(tx, AccountEntryFees)
+	if err != nil {
+		return err
+	}
+	to, err := UserAccount(tx, playerID)
+	if err != nil {
+		return err
+	}
+	_, err = Transfer(tx, entryFeeKey(CreditRefund, tournamentID, playerID, registeredAt), CreditRefund, "Refunded entry fee", tournamentID, from, to, fee)
+	return err
+}
+
+// Each registration is charged and refunded at most once, a player who leaves and registers again gets a new key
+func entryFeeKey(kind string, tournamentID int, playerID string, registeredAt time.Time) string {
+	return fmt.Sprintf("%s:%d:%s:%d", kind, tournamentID, playerID, registeredAt.UnixNano())
+}
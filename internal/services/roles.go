@@ -0,0 +1,151 @@
+package services
+
+import (
+	"Roshamble/internal/auth"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/lib/pq"
+)
+
+var ErrLastAdmin = errors.New("cannot revoke the last admin")
+
+// UserRole is a player's role as shown in role management
+type UserRole struct {
+	UserID   string
+	Username string
+	Role     string
+}
+
+// Roles stored for the player, plain users have none
+func GetUserRoles(db *sql.DB, userID string) ([]string, error) {
+	roles := []string{}
+	rows, err := db.Query("SELECT role FROM user_roles WHERE user_id = $1 ORDER BY role", userID)
+	if err != nil {
+		return roles, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return roles, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// Invite level and roles as currently stored, to compare against the player's token
+func GetUserAccess(db *sql.DB, userID string) (int, []string, error) {
+	level := 0
+	roles := []string{}
+	err := db.QueryRow("SELECT invite_level, ARRAY(SELECT role FROM user_roles WHERE user_id = u.id ORDER BY role) FROM users u WHERE u.id = $1", userID).Scan(&level, pq.Array(&roles))
+	return level, roles, err
+}
+
+// Every player holding a role
+func ListUserRoles(db *sql.DB) ([]UserRole, error) {
+	roles := []UserRole{}
+	rows, err := db.Query("SELECT u.id, u.username, r.role FROM user_roles r JOIN users u ON u.id = r.user_id ORDER BY r.role, u.username")
+	if err != nil {
+		return roles, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := UserRole{}
+		if err := rows.Scan(&r.UserID, &r.Username, &r.Role); err != nil {
+			return roles, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+// Look a player up by username or phone number, for role management from the command line
+func FindUserID(db *sql.DB, usernameOrPhone string) (string, error) {
+	var id string
+	phone, _ := NormalizePhone(usernameOrPhone)
+	err := db.QueryRow("SELECT id FROM users WHERE username = $1 OR phone = $2", usernameOrPhone, phone).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("no user %q", usernameOrPhone)
+	}
+	return id, err
+}
+
+// Give a player a role, changedBy is empty when run from the command line
+func GrantRole(db *sql.DB, userID, role, changedBy string) error {
+	if !auth.ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO user_roles (user_id, role) VALUES ($1, $2) ON CONFLICT DO NOTHING", userID, role)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	if err := recordRoleChange(tx, userID, role, "grant", changedBy); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.Info("Role granted", "userID", userID, "role", role, "changedBy", changedBy)
+	return nil
+}
+
+func RevokeRole(db *sql.DB, userID, role, changedBy string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialise admin changes so two admins cannot revoke each other at once
+	if role == auth.RoleAdmin {
+		if _, err := tx.Exec("LOCK TABLE user_roles IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			return err
+		}
+		others := 0
+		if err := tx.QueryRow("SELECT COUNT(*) FROM user_roles WHERE role = $1 AND user_id <> $2", auth.RoleAdmin, userID).Scan(&others); err != nil {
+			return err
+		}
+		if others == 0 {
+			return ErrLastAdmin
+		}
+	}
+
+	res, err := tx.Exec("DELETE FROM user_roles WHERE user_id = $1 AND role = $2", userID, role)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	if err := recordRoleChange(tx, userID, role, "revoke", changedBy); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.Info("Role revoked", "userID", userID, "role", role, "changedBy", changedBy)
+	return nil
+}
+
+func recordRoleChange(tx *sql.Tx, userID, role, action, changedBy string) error {
+	_, err := tx.Exec("INSERT INTO role_changes (user_id, role, action, changed_by) VALUES ($1, $2, $3, NULLIF($4, '')::UUID)", userID, role, action, changedBy)
+	return err
+}
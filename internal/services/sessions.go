@@ -0,0 +1,187 @@
+package services
+
+import (
+	"Roshamble/internal/auth"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A refresh token replaced this recently still works, so requests racing a rotation keep the player signed in
+const refreshGracePeriod = 30 * time.Second
+
+var ErrSessionExpired = errors.New("session expired or revoked")
+
+// Session is a signed in device
+type Session struct {
+	ID         string
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	// Whether this is the session the list was requested from
+	Current bool
+}
+
+// Short description of the browser and platform the session was started from
+func (s Session) Device() string {
+	ua := s.UserAgent
+	browser := "Browser"
+	for _, b := range []string{"Edg", "Firefox", "Chrome", "Safari"} {
+		if strings.Contains(ua, b) {
+			browser = strings.Replace(b, "Edg", "Edge", 1)
+			break
+		}
+	}
+	for _, p := range []string{"iPhone", "iPad", "Android", "Mac OS", "Windows", "Linux"} {
+		if strings.Contains(ua, p) {
+			return browser + " on " + strings.TrimSuffix(p, " OS")
+		}
+	}
+	return browser
+}
+
+func (s Session) LastUsedString() string {
+	return s.LastUsedAt.Format("Jan 2 3:04 PM")
+}
+
+// SessionStore backs auth.Sessions with the user_sessions table
+type SessionStore struct {
+	DB *sql.DB
+}
+
+func NewSessionStore(db *sql.DB) *SessionStore {
+	return &SessionStore{DB: db}
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Start a session for the device making the request, returning its access and refresh tokens
+func CreateSession(c *gin.Context, db *sql.DB, claims Claims) (string, string, error) {
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = db.QueryRow("INSERT INTO user_sessions (user_id, refresh_token_hash, user_agent, ip, expires_at) VALUES ($1, $2, $3, $4, NOW() + make_interval(secs => $5)) RETURNING id",
+		claims.ID, hashRefreshToken(refreshToken), c.Request.UserAgent(), c.ClientIP(), auth.SessionLifetime.Seconds()).Scan(&claims.SessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := IssueToken(claims)
+	return accessToken, refreshToken, err
+}
+
+func (s *SessionStore) Active(sessionID string) bool {
+	active := false
+	err := s.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM user_sessions WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW())", sessionID).Scan(&active)
+	if err != nil {
+		slog.Error("Error checking session", "error", err)
+		return false
+	}
+	return active
+}
+
+// Rotate the refresh token and issue an access token with the player's current claims
+func (s *SessionStore) Refresh(c *gin.Context, refreshToken string) (string, string, error) {
+	tx, err := s.DB.BeginTx(c, nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	hash := hashRefreshToken(refreshToken)
+	claims := Claims{}
+	err = tx.QueryRow(`SELECT s.id, u.id, u.username, u.invite_level
+		FROM user_sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE (s.refresh_token_hash = $1 OR (s.previous_token_hash = $1 AND s.rotated_at > NOW() - make_interval(secs => $2)))
+		AND s.revoked_at IS NULL AND s.expires_at > NOW()
+		FOR UPDATE OF s`, hash, refreshGracePeriod.Seconds()).Scan(&claims.SessionID, &claims.ID, &claims.Username, &claims.InviteLevel)
+	if err == sql.ErrNoRows {
+		return "", "", ErrSessionExpired
+	} else if err != nil {
+		return "", "", err
+	}
+
+	newToken, err := newRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+	_, err = tx.Exec(`UPDATE user_sessions SET
+			previous_token_hash = refresh_token_hash, refresh_token_hash = $1, rotated_at = NOW(),
+			last_used_at = NOW(), expires_at = NOW() + make_interval(secs => $2), ip = $3, user_agent = $4
+		WHERE id = $5`,
+		hashRefreshToken(newToken), auth.SessionLifetime.Seconds(), c.ClientIP(), c.Request.UserAgent(), claims.SessionID)
+	if err != nil {
+		return "", "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+
+	if claims.Roles, err = GetUserRoles(s.DB, claims.ID); err != nil {
+		return "", "", err
+	}
+	accessToken, err := IssueToken(claims)
+	return accessToken, newToken, err
+}
+
+// Signed in devices, most recently used first
+func ListSessions(db *sql.DB, userID, currentID string) ([]Session, error) {
+	sessions := []Session{}
+
+	rows, err := db.Query("SELECT id, user_agent, ip, created_at, COALESCE(last_used_at, created_at) FROM user_sessions WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW() ORDER BY last_used_at DESC NULLS LAST", userID)
+	if err != nil {
+		return sessions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := Session{}
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsedAt); err != nil {
+			return sessions, err
+		}
+		s.Current = s.ID == currentID
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Sign a single device out, only the owner of the session can revoke it
+func RevokeSession(db *sql.DB, userID, sessionID string) error {
+	_, err := db.Exec("UPDATE user_sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL", sessionID, userID)
+	return err
+}
+
+// Sign out everywhere
+func RevokeAllSessions(db *sql.DB, userID string) error {
+	_, err := db.Exec("UPDATE user_sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
+
+func RevokeSessionByRefreshToken(db *sql.DB, refreshToken string) error {
+	_, err := db.Exec("UPDATE user_sessions SET revoked_at = NOW() WHERE refresh_token_hash = $1 AND revoked_at IS NULL", hashRefreshToken(refreshToken))
+	return err
+}
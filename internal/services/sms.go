@@ -0,0 +1,40 @@
+package services
+
+import (
+	"Roshamble/internal/sms"
+	"context"
+	"database/sql"
+	"log/slog"
+)
+
+// Render a template and text it to the phone, tracking its delivery in sms_messages
+func SendSMS(ctx context.Context, db *sql.DB, sender sms.Sender, phone, template string, data any) error {
+	body, err := sms.Render(template, data)
+	if err != nil {
+		return err
+	}
+
+	var id int
+	if err := db.QueryRow("INSERT INTO sms_messages (phone, template) VALUES ($1, $2) RETURNING id", phone, template).Scan(&id); err != nil {
+		return err
+	}
+
+	res, sendErr := sender.Send(ctx, phone, body)
+	status := res.Status
+	errMessage := sql.NullString{}
+	if sendErr != nil {
+		status = sms.StatusFailed
+		errMessage = sql.NullString{String: sendErr.Error(), Valid: true}
+	}
+
+	if _, err := db.Exec("UPDATE sms_messages SET status = $1, provider_id = NULLIF($2, ''), error = $3, updated_at = NOW() WHERE id = $4", status, res.ProviderID, errMessage, id); err != nil {
+		slog.Error("Error recording sms status", "smsID", id, "error", err)
+	}
+	return sendErr
+}
+
+// Record a delivery status reported by the provider
+func UpdateSMSStatus(db *sql.DB, providerID, status, errorCode string) error {
+	_, err := db.Exec("UPDATE sms_messages SET status = $1, error = COALESCE(NULLIF($2, ''), error), updated_at = NOW() WHERE provider_id = $3", status, errorCode, providerID)
+	return err
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var ErrTournamentOwned = errors.New("tournament is running on another server")
+
+// How often the lock connection is checked, a dead one has lost its locks until it is replaced
+const lockPingInterval = 10 * time.Second
+
+// TournamentTemplate creates a tournament for every slot of its cron schedule. The tournament settings
+// are copied straight from the tournament_templates row when each one is created.
+type TournamentTemplate struct {
+	ID       int
+	Name     string
+	Schedule string
+	// IANA zone the schedule is read in
+	Timezone string
+	// How long before the start tournaments are created and open for registration
+	CreateAhead time.Duration
+}
+
+func ActiveTournamentTemplates(db *sql.DB) ([]TournamentTemplate, error) {
+	templates := []TournamentTemplate{}
+
+	rows, err := db.Query("SELECT id, name, schedule, timezone, create_ahead_hours FROM tournament_templates WHERE active ORDER BY id")
+	if err != nil {
+		return templates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := TournamentTemplate{}
+		hours := 0
+		if err := rows.Scan(&t.ID, &t.Name, &t.Schedule, &t.Timezone, &hours); err != nil {
+			return templates, err
+		}
+		t.CreateAhead = time.Duration(hours) * time.Hour
+		templates = append(templates, t)
+	}
+
+	return templates, rows.Err()
+}
+
+// Create the template's tournaments for the given start dates, skipping slots that already have one.
+// Returns how many were created. Only one server fills in a template at a time, the others skip it.
+func CreateScheduledTournaments(db *sql.DB, tpl TournamentTemplate, slots []time.Time) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	locked := false
+	if err := tx.QueryRow("SELECT pg_try_advisory_xact_lock(hashtext($1))", fmt.Sprintf("tournament-template:%d", tpl.ID)).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	created := 0
+	for _, slot := range slots {
+		res, err := tx.Exec(`INSERT INTO tournaments (name, description, emoji, prize, prize_url, invite_level, start_date, location, round_timeout_seconds,
+				format, best_of, sudden_death, move_set, max_players, check_in_minutes, entry_fee, template_id)
+			SELECT name, description, emoji, prize, prize_url, invite_level, $2, location, round_timeout_seconds,
+				format, best_of, sudden_death, move_set, max_players, check_in_minutes, entry_fee, id
+			FROM tournament_templates WHERE id = $1
+			ON CONFLICT (template_id, start_date) DO NOTHING`, tpl.ID, slot.UTC())
+		if err != nil {
+			return created, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return created, err
+		} else if n > 0 {
+			created++
+		}
+	}
+
+	return created, tx.Commit()
+}
+
+// Tournaments that have not started and are due to be run, either because check-in has opened
+// or because they start within the given lead time
+func DueTournaments(db *sql.DB, lead time.Duration) ([]Tournament, error) {
+	ids := []int{}
+
+	rows, err := db.Query("SELECT id FROM tournaments WHERE status IN ('scheduled', 'open') AND start_date - make_interval(mins => check_in_minutes) - make_interval(secs => $1) <= NOW() ORDER BY start_date",
+		lead.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		id := 0
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ts := make([]Tournament, 0, len(ids))
+	for _, id := range ids {
+		t, err := GetTournamentByID(db, id)
+		if err != nil {
+			return ts, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// TournamentLocker holds every tournament lock this server has on one dedicated connection. Session
+// advisory locks belong to the connection that took them, so pinning a pooled connection per
+// tournament would starve the pool once enough tournaments are loaded.
+type TournamentLocker struct {
+	db *sql.DB
+	// Called with a tournament whose lock another server took while the connection was down,
+	// this server has to stop running it
+	onLost func(tournamentID int)
+
+	mu   sync.Mutex
+	conn *sql.Conn
+	held map[int]bool
+}
+
+func NewTournamentLocker(db *sql.DB, onLost func(tournamentID int)) *TournamentLocker {
+	return &TournamentLocker{db: db, onLost: onLost, held: map[int]bool{}}
+}
+
+// Run pings the lock connection until the context is cancelled, so a dropped connection is
+// replaced and any locks lost with it are reported without waiting for the next lock or release
+func (l *TournamentLocker) Run(ctx context.Context) {
+	ticker := time.NewTicker(lockPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.ping(ctx)
+		}
+	}
+}
+
+func (l *TournamentLocker) ping(ctx context.Context) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.held) == 0 {
+		return
+	}
+	if l.conn != nil {
+		if err := l.conn.PingContext(ctx); err == nil {
+			return
+		}
+		slog.Warn("Tournament lock connection lost, reconnecting", "locks", len(l.held))
+		l.reset()
+	}
+	if _, err := l.connection(ctx); err != nil {
+		slog.Error("Error reconnecting tournament locks", "error", err)
+	}
+}
+
+// TournamentLock is held by the one server running a tournament
+type TournamentLock struct {
+	locker       *TournamentLocker
+	tournamentID int
+}
+
+func tournamentLockKey(tournamentID int) string {
+	return fmt.Sprintf("tournament:%d", tournamentID)
+}
+
+// The lock connection, reconnecting if it was lost. Postgres drops a connection's locks with it, so
+// they are taken again and any that another server has claimed in the meantime are handed to onLost.
+// Must be called with the mutex held.
+func (l *TournamentLocker) connection(ctx context.Context) (*sql.Conn, error) {
+	if l.conn != nil {
+		return l.conn, nil
+	}
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l.conn = conn
+
+	for id := range l.held {
+		locked := false
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", tournamentLockKey(id)).Scan(&locked); err != nil {
+			l.reset()
+			return nil, err
+		}
+		if !locked {
+			slog.Error("Lost tournament lock to another server", "tournamentID", id)
+			delete(l.held, id)
+			// Not under the mutex, stopping the tournament releases its lock
+			go l.onLost(id)
+		}
+	}
+	return conn, nil
+}
+
+// Drop a broken connection, the next lock or release reconnects. Must be called with the mutex held.
+func (l *TournamentLocker) reset() {
+	l.conn.Close()
+	l.conn = nil
+}
+
+// Claim a tournament for this server, failing with ErrTournamentOwned if another server already has it
+func (l *TournamentLocker) Lock(ctx context.Context, tournamentID int) (*TournamentLock, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	conn, err := l.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Advisory locks stack, taking one twice would need two unlocks
+	if l.held[tournamentID] {
+		return nil, ErrTournamentOwned
+	}
+
+	locked := false
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", tournamentLockKey(tournamentID)).Scan(&locked); err != nil {
+		l.reset()
+		return nil, err
+	}
+	if !locked {
+		return nil, ErrTournamentOwned
+	}
+	l.held[tournamentID] = true
+	return &TournamentLock{locker: l, tournamentID: tournamentID}, nil
+}
+
+// Release the tournament so another server can pick it up
+func (tl *TournamentLock) Release() {
+	l := tl.locker
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.held[tl.tournamentID] {
+		return
+	}
+	delete(l.held, tl.tournamentID)
+	if l.conn == nil {
+		// Gone with the connection already
+		return
+	}
+	if _, err := l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", tournamentLockKey(tl.tournamentID)); err != nil {
+		slog.Error("Error releasing tournament lock", "tournamentID", tl.tournamentID, "error", err)
+		l.reset()
+	}
+}
@@ -1,13 +1,42 @@
 package services
 
 import (
+	"Roshamble/internal/tournament"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
+// Tournament lifecycle, stored in tournaments.status
+const (
+	TournamentScheduled = "scheduled"
+	TournamentOpen      = "open"
+	TournamentRunning   = "running"
+	TournamentFinished  = "finished"
+	TournamentCancelled = "cancelled"
+)
+
+// The statuses a tournament is allowed to move to each status from
+var tournamentTransitions = map[string][]string{
+	TournamentOpen:      {TournamentScheduled},
+	TournamentRunning:   {TournamentScheduled, TournamentOpen},
+	TournamentFinished:  {TournamentRunning},
+	TournamentCancelled: {TournamentScheduled, TournamentOpen, TournamentRunning},
+}
+
+var ErrInvalidTournament = errors.New("invalid tournament")
+
+// Layout of the datetime-local inputs on the admin tournament form
+const dateTimeInput = "2006-01-02T15:04"
+
 type TournamentData struct {
 	OpenTournament      Tournament
 	OngoingTournament   Tournament
@@ -25,6 +54,16 @@ type Tournament struct {
 	InviteLevel    int    `form:"invite_level"`
 	StartDate      string `form:"start_date"`
 	Location       string `form:"location"`
+	Status         string
+	RoundTimeout   int    `form:"round_timeout"` // seconds
+	Format         string `form:"format"`
+	BestOf         int    `form:"best_of"`
+	SuddenDeath    bool   `form:"sudden_death"`
+	MoveSet        string `form:"move_set"`
+	MaxPlayers     int    `form:"max_players"`            // 0 for no limit
+	ClosesAt       string `form:"registration_closes_at"` // registration, empty to keep it open until the start date
+	CheckInMinutes int    `form:"check_in_minutes"`
+	EntryFee       int    `form:"entry_fee"` // credits
 	WinnerID       string
 	WinnerUsername string
 }
@@ -42,7 +81,7 @@ func GetTournamentData(c *gin.Context, db *sql.DB, claims Claims) (TournamentDat
 	tournamentData := TournamentData{}
 	tournamentQueue := []Tournament{}
 
-	rows, err := db.Query("SELECT id, name, COALESCE(description, ''), prize, COALESCE(prize_url, ''), COALESCE(emoji, ''), start_date FROM tournaments WHERE winner_id is NULL AND invite_level <= $1 AND start_date > NOW() ORDER BY start_date ASC LIMIT 4", claims.InviteLevel)
+	rows, err := db.Query("SELECT id, name, COALESCE(description, ''), prize, COALESCE(prize_url, ''), COALESCE(emoji, ''), start_date, entry_fee FROM tournaments WHERE status IN ('scheduled', 'open') AND invite_level <= $1 AND start_date > NOW() ORDER BY start_date ASC LIMIT 4", claims.InviteLevel)
 	if err != nil {
 		return tournamentData, err
 	}
@@ -50,7 +89,7 @@ func GetTournamentData(c *gin.Context, db *sql.DB, claims Claims) (TournamentDat
 
 	for rows.Next() {
 		var t Tournament
-		if err := rows.Scan(&t.ID, &t.Name, &t.Description, &t.Prize, &t.Emoji, &t.PrizeURL, &t.StartDate); err != nil {
+		if err := rows.Scan(&t.ID, &t.Name, &t.Description, &t.Prize, &t.PrizeURL, &t.Emoji, &t.StartDate, &t.EntryFee); err != nil {
 			return tournamentData, err
 		}
 		tournamentQueue = append(tournamentQueue, t)
@@ -81,8 +120,8 @@ func GetTournamentData(c *gin.Context, db *sql.DB, claims Claims) (TournamentDat
 	}
 
 	tournamentData.OngoingTournament = Tournament{}
-	row = db.QueryRow("SELECT id, name, start_date, prize, COALESCE(prize_url, ''), COALESCE(emoji,'') FROM tournaments WHERE winner_id IS NULL AND start_date >= NOW() ORDER BY start_date DESC LIMIT 1")
-	if err := row.Scan(&tournamentData.OngoingTournament.ID, &tournamentData.OngoingTournament.Name, &tournamentData.OngoingTournament.Prize, &tournamentData.OngoingTournament.PrizeURL, &tournamentData.OngoingTournament.Emoji, &tournamentData.OngoingTournament.StartDate); err != nil && err != sql.ErrNoRows {
+	row = db.QueryRow("SELECT id, name, start_date, prize, COALESCE(prize_url, ''), COALESCE(emoji,'') FROM tournaments WHERE status = 'running' ORDER BY start_date DESC LIMIT 1")
+	if err := row.Scan(&tournamentData.OngoingTournament.ID, &tournamentData.OngoingTournament.Name, &tournamentData.OngoingTournament.StartDate, &tournamentData.OngoingTournament.Prize, &tournamentData.OngoingTournament.PrizeURL, &tournamentData.OngoingTournament.Emoji); err != nil && err != sql.ErrNoRows {
 		slog.Error("Error scanning ongoing tournament", slog.Any("error", err))
 	}
 
@@ -90,35 +129,198 @@ func GetTournamentData(c *gin.Context, db *sql.DB, claims Claims) (TournamentDat
 }
 
 func GetTournament(c *gin.Context, db *sql.DB) (Tournament, error) {
-	t := Tournament{}
+	tID, err := strconv.Atoi(c.Param("tournamentID"))
+	if err != nil {
+		slog.Error("Error parsing tournamentID from url param", "error", err.Error())
+		return Tournament{}, err
+	}
+	return GetTournamentByID(db, tID)
+}
 
-	tID := c.Param("tournamentID")
+func GetTournamentByID(db *sql.DB, tID int) (Tournament, error) {
+	t := Tournament{}
+	closesAt := sql.NullString{}
 
-	row := db.QueryRow("SELECT id, name, prize, COALESCE(prize_url, ''), start_date FROM tournaments WHERE id = $1", tID)
+	row := db.QueryRow(`SELECT t.id, t.name, COALESCE(t.description, ''), COALESCE(t.emoji, ''), t.prize, COALESCE(t.prize_url, ''), COALESCE(t.invite_level, 0), t.start_date, COALESCE(t.location, ''), t.status, t.round_timeout_seconds,
+		t.format, t.best_of, t.sudden_death, t.move_set, COALESCE(t.max_players, 0), t.registration_closes_at, t.check_in_minutes, t.entry_fee, COALESCE(u.username, '')
+		FROM tournaments t LEFT JOIN users u ON u.id = t.winner_id WHERE t.id = $1`, tID)
 
-	err := row.Scan(&t.ID, &t.Name, &t.Prize, &t.PrizeURL, &t.StartDate)
+	err := row.Scan(&t.ID, &t.Name, &t.Description, &t.Emoji, &t.Prize, &t.PrizeURL, &t.InviteLevel, &t.StartDate, &t.Location, &t.Status, &t.RoundTimeout, &t.Format, &t.BestOf, &t.SuddenDeath, &t.MoveSet, &t.MaxPlayers, &closesAt, &t.CheckInMinutes, &t.EntryFee, &t.WinnerUsername)
 	if err != nil {
 		slog.Error("Error scanning tournament by id", "error", err.Error())
 	}
+	t.ClosesAt = closesAt.String
 
 	return t, err
 }
 
-func CreateTournament(c *gin.Context, db *sql.DB) error {
-	tournament := Tournament{}
+// Create a scheduled tournament from the admin form, returning it with its new ID.
+// The bound tournament is returned on failure too so the form can be shown again.
+func CreateTournament(c *gin.Context, db *sql.DB) (Tournament, error) {
+	t := Tournament{}
 
-	if err := c.Bind(&tournament); err != nil {
+	if err := c.ShouldBind(&t); err != nil {
 		slog.Error("Error binding tournament data", slog.Any("error", err))
-		return err
+		return t, fmt.Errorf("%w: %v", ErrInvalidTournament, err)
+	}
+	if err := t.validate(); err != nil {
+		return t, err
 	}
-	_, err := db.Exec("INSERT INTO tournaments (name, start_date) VALUES ($1, $2, $3)",
-		tournament.Prize, tournament.StartDate, tournament.PrizeURL, tournament.Emoji)
 
+	err := db.QueryRow(`INSERT INTO tournaments (name, description, emoji, prize, prize_url, invite_level, start_date, location, round_timeout_seconds,
+			format, best_of, sudden_death, move_set, max_players, registration_closes_at, check_in_minutes, entry_fee)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, NULLIF($5, ''), $6, $7, NULLIF($8, ''), $9, $10, $11, $12, $13, NULLIF($14, 0), NULLIF($15, '')::TIMESTAMP, $16, $17)
+		RETURNING id, start_date, status`,
+		t.Name, t.Description, t.Emoji, t.Prize, t.PrizeURL, t.InviteLevel, t.StartDate, t.Location, t.RoundTimeout,
+		t.Format, t.BestOf, t.SuddenDeath, t.MoveSet, t.MaxPlayers, t.ClosesAt, t.CheckInMinutes, t.EntryFee).Scan(&t.ID, &t.StartDate, &t.Status)
 	if err != nil {
 		slog.Error("Error inserting tournament into database", slog.Any("error", err))
 	}
 
-	return err
+	return t, err
+}
+
+// Update a tournament from the admin form. Only tournaments that have not started can be changed.
+func UpdateTournament(c *gin.Context, db *sql.DB, tournamentID int) (Tournament, error) {
+	t := Tournament{}
+
+	if err := c.ShouldBind(&t); err != nil {
+		slog.Error("Error binding tournament data", slog.Any("error", err))
+		return t, fmt.Errorf("%w: %v", ErrInvalidTournament, err)
+	}
+	t.ID = tournamentID
+	if err := t.validate(); err != nil {
+		return t, err
+	}
+
+	err := db.QueryRow(`UPDATE tournaments SET name = $1, description = NULLIF($2, ''), emoji = NULLIF($3, ''), prize = $4, prize_url = NULLIF($5, ''),
+			invite_level = $6, start_date = $7, location = NULLIF($8, ''), round_timeout_seconds = $9, format = $10, best_of = $11,
+			sudden_death = $12, move_set = $13, max_players = NULLIF($14, 0), registration_closes_at = NULLIF($15, '')::TIMESTAMP,
+			check_in_minutes = $16, entry_fee = $17
+		WHERE id = $18 AND status IN ('scheduled', 'open')
+		RETURNING status, start_date`,
+		t.Name, t.Description, t.Emoji, t.Prize, t.PrizeURL, t.InviteLevel, t.StartDate, t.Location, t.RoundTimeout, t.Format, t.BestOf,
+		t.SuddenDeath, t.MoveSet, t.MaxPlayers, t.ClosesAt, t.CheckInMinutes, t.EntryFee, t.ID).Scan(&t.Status, &t.StartDate)
+	if err == sql.ErrNoRows {
+		return t, ErrTournamentStarted
+	} else if err != nil {
+		slog.Error("Error updating tournament", "tournamentID", t.ID, "error", err)
+	}
+
+	return t, err
+}
+
+// Copy a tournament's settings into a new scheduled tournament a week after the original, or tomorrow if that has already passed
+func CloneTournament(db *sql.DB, tournamentID int) (int, error) {
+	id := 0
+	err := db.QueryRow(`INSERT INTO tournaments (name, description, emoji, prize, prize_url, invite_level, start_date, location, round_timeout_seconds,
+			format, best_of, sudden_death, move_set, max_players, registration_closes_at, check_in_minutes, entry_fee)
+		SELECT name, description, emoji, prize, prize_url, invite_level, GREATEST(start_date + INTERVAL '7 days', NOW() + INTERVAL '1 day'), location, round_timeout_seconds,
+			format, best_of, sudden_death, move_set, max_players, NULL, check_in_minutes, entry_fee
+		FROM tournaments WHERE id = $1
+		RETURNING id`, tournamentID).Scan(&id)
+	return id, err
+}
+
+// Every tournament for the admin console, upcoming first then most recent
+func ListTournaments(db *sql.DB, limit int) ([]Tournament, error) {
+	ts := []Tournament{}
+
+	rows, err := db.Query(`SELECT t.id, COALESCE(t.name, ''), COALESCE(t.emoji, ''), t.prize, COALESCE(t.invite_level, 0), t.start_date, t.status, t.format, COALESCE(u.username, '')
+		FROM tournaments t
+		LEFT JOIN users u ON u.id = t.winner_id
+		ORDER BY t.status IN ('scheduled', 'open', 'running') DESC, CASE WHEN t.status IN ('scheduled', 'open', 'running') THEN t.start_date END ASC, t.start_date DESC
+		LIMIT $1`, limit)
+	if err != nil {
+		return ts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := Tournament{}
+		if err := rows.Scan(&t.ID, &t.Name, &t.Emoji, &t.Prize, &t.InviteLevel, &t.StartDate, &t.Status, &t.Format, &t.WinnerUsername); err != nil {
+			return ts, err
+		}
+		ts = append(ts, t)
+	}
+
+	return ts, rows.Err()
+}
+
+// Whether the tournament's settings can still be changed
+func (t *Tournament) Editable() bool {
+	return t.Status == TournamentScheduled || t.Status == TournamentOpen
+}
+
+// Start date in the format of a datetime-local input
+func (t *Tournament) StartDateInput() string {
+	return dateInput(t.StartDate)
+}
+
+func (t *Tournament) ClosesAtInput() string {
+	return dateInput(t.ClosesAt)
+}
+
+// Dates straight from a form are already in the input's layout
+func dateInput(date string) string {
+	parsed, err := time.Parse(time.RFC3339Nano, date)
+	if err != nil {
+		return date
+	}
+	return parsed.Format(dateTimeInput)
+}
+
+// Fill in defaults for anything left blank and check the rest against the database constraints
+func (t *Tournament) validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	t.Prize = strings.TrimSpace(t.Prize)
+	if t.Format == "" {
+		t.Format = tournament.FormatSwiss
+	}
+	if t.MoveSet == "" {
+		t.MoveSet = tournament.DefaultRuleset.MoveSet.Name
+	}
+	if t.BestOf == 0 {
+		t.BestOf = tournament.DefaultRuleset.BestOf
+	}
+	if t.RoundTimeout == 0 {
+		t.RoundTimeout = int(tournament.DefaultRoundTimeout / time.Second)
+	}
+
+	var problem string
+	switch {
+	case t.Name == "":
+		problem = "a name is required"
+	case t.Prize == "":
+		problem = "a prize is required"
+	case t.StartDate == "":
+		problem = "a start date is required"
+	case utf8.RuneCountInString(t.Emoji) > 10:
+		problem = "the emoji can be at most 10 characters"
+	case t.InviteLevel < 0:
+		problem = "the invite level cannot be negative"
+	case t.BestOf < 1 || t.BestOf%2 == 0:
+		problem = "best of must be an odd number of rounds"
+	case t.RoundTimeout < 1:
+		problem = "the round timeout must be at least a second"
+	case t.MaxPlayers < 0 || t.MaxPlayers == 1:
+		problem = "max players must be at least 2, or 0 for no limit"
+	case t.CheckInMinutes < 0:
+		problem = "check in minutes cannot be negative"
+	case t.EntryFee < 0:
+		problem = "the entry fee cannot be negative"
+	}
+	if problem != "" {
+		return fmt.Errorf("%w: %s", ErrInvalidTournament, problem)
+	}
+
+	if _, err := tournament.FormatByName(t.Format); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTournament, err)
+	}
+	if _, err := tournament.MoveSetByName(t.MoveSet); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTournament, err)
+	}
+	return nil
 }
 
 func GetPastTournaments(c *gin.Context, db *sql.DB) ([]Tournament, error) {
@@ -142,20 +344,54 @@ func GetPastTournaments(c *gin.Context, db *sql.DB) ([]Tournament, error) {
 	return pt, err
 }
 
-func RemoveTournamentPlayer(c *gin.Context, db *sql.DB, tournamentID int, claims Claims) error {
-	_, err := db.Exec("DELETE FROM tournament_players WHERE tournament_id = $1 AND player_id = $2", tournamentID, claims.ID)
+// Settings the in memory tournament is run with
+func (t *Tournament) GameConfig() tournament.Config {
+	format, err := tournament.FormatByName(t.Format)
 	if err != nil {
-		slog.Error("Error removing player from tournament", "error", err.Error())
+		slog.Error("Error loading tournament format, falling back to swiss", "tournamentID", t.ID, "error", err)
+		format = tournament.Swiss{}
+	}
+	moveSet, err := tournament.MoveSetByName(t.MoveSet)
+	if err != nil {
+		slog.Error("Error loading tournament move set, falling back to rock paper scissors", "tournamentID", t.ID, "error", err)
+		moveSet = tournament.RockPaperScissors
+	}
+	return tournament.Config{
+		RoundTimeout: time.Duration(t.RoundTimeout) * time.Second,
+		Format:       format,
+		Ruleset: tournament.Ruleset{
+			BestOf:      t.BestOf,
+			SuddenDeath: t.SuddenDeath,
+			MoveSet:     moveSet,
+		},
 	}
-
-	return err
 }
 
-func AddTournamentPlayer(c *gin.Context, db *sql.DB, tournamentID int, claims Claims) error {
-	_, err := db.Exec("INSERT INTO tournament_players (tournament_id, player_id) VALUES tournament_id = $1, player_id = $2", tournamentID, claims.ID)
+// Time the tournament is due to start
+func (t *Tournament) StartTime() time.Time {
+	res, err := time.Parse(time.RFC3339Nano, t.StartDate)
 	if err != nil {
-		slog.Error("Error adding player to tournament", "error", err.Error())
+		slog.Error("Error parsing tournament start date", "tournamentID", t.ID, "error", err.Error())
+		return time.Now()
 	}
+	return res
+}
 
-	return err
+// Move a tournament to a new status, failing if the transition is not allowed from its current status
+func UpdateTournamentStatus(db *sql.DB, tournamentID int, status string) error {
+	from, ok := tournamentTransitions[status]
+	if !ok {
+		return fmt.Errorf("unknown tournament status %q", status)
+	}
+
+	res, err := db.Exec("UPDATE tournaments SET status = $1 WHERE id = $2 AND status = ANY($3)", status, tournamentID, pq.Array(from))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("tournament %d cannot move to %s", tournamentID, status)
+	}
+	return nil
 }
@@ -1,10 +1,12 @@
 package services
 
 import (
+	"Roshamble/internal/auth"
+	"Roshamble/internal/sms"
 	"database/sql"
 	"log/slog"
-	"math/rand"
 	"os"
+	"strings"
 	"time"
 
 	petname "github.com/dustinkirkland/golang-petname"
@@ -23,13 +25,15 @@ type Claims struct {
 	Username    string
 	Phone       string
 	InviteLevel int
+	SessionID   string
+	Roles       []string
 }
 
 type OTPRequest struct {
 	Phone string `form:"phone"`
 }
 
-func TriggerOTP(c *gin.Context, db *sql.DB) (string, string, string) {
+func TriggerOTP(c *gin.Context, db *sql.DB, sender sms.Sender) (string, string, string) {
 	req := OTPRequest{}
 
 	err := c.Bind(&req)
@@ -37,102 +41,153 @@ func TriggerOTP(c *gin.Context, db *sql.DB) (string, string, string) {
 		return "", "", "Bad request. Please try again later"
 	}
 
+	req.Phone, err = NormalizePhone(req.Phone)
+	if err != nil {
+		return "", "", "Please enter a valid phone number"
+	}
+
+	if wait, err := OTPLockout(db, req.Phone, c.ClientIP()); err != nil {
+		slog.Error("Error checking OTP lockout", slog.Any("error", err))
+		return "", "", "There was a problem. Please try again later"
+	} else if wait > 0 {
+		return "", "", lockoutMessage(wait)
+	}
+
 	// To limit the number of requests, check if the phone number already has a verification code created in the last 2 minutes
 	row := db.QueryRow("SELECT created_at FROM otp WHERE phone = $1 AND created_at > NOW() - INTERVAL '2 minutes'", req.Phone)
 
 	if err := row.Scan(&time.Time{}); err == nil {
-		return req.Phone, "A verification code has already been sent to this phone numberuser.sdfj Please wait a moment before requesting another.", ""
+		return req.Phone, "A verification code has already been sent to this phone number. Please wait a moment before requesting another.", ""
 	} else if err != sql.ErrNoRows {
 		slog.Error("Error checking for existing OTP", slog.Any("error", err))
 		return "", "", "There was a problem. Please try again later"
 	}
 
 	// Generate a random 6-digit verification code
-	code := 100000 + rand.Intn(900000)
-	// Store the code in the database
-	_, err = db.Exec("INSERT INTO otp (phone, code) VALUES ($1, $2) ON CONFLICT (phone) DO UPDATE SET code = $2, created_at = NOW()", req.Phone, code)
+	code, err := GenerateOTP()
+	if err != nil {
+		slog.Error("Failed to generate OTP", slog.Any("error", err))
+		return "", "", "There was a problem sending the verification code. Please try again later"
+	}
+	// Store the code in the database, hashed so it cannot be read back
+	_, err = db.Exec("INSERT INTO otp (phone, code_hash) VALUES ($1, $2) ON CONFLICT (phone) DO UPDATE SET code_hash = $2, attempts = 0, created_at = NOW()", req.Phone, HashOTP(req.Phone, code))
 	if err != nil {
 		slog.Error("Failed to insert or update OTP", slog.Any("error", err))
 		return "", "", "There was a problem sending the verification code. Please try again later"
 	}
 
-	// TODO: Send the verification code to the user's phone number using an SMS service
-	// For now, we'll just log it
+	err = SendSMS(c, db, sender, req.Phone, sms.TemplateOTP, sms.OTP{Code: code, Minutes: int(OTPLifetime / time.Minute)})
+	if err != nil {
+		slog.Error("Failed to send OTP", slog.Any("error", err))
+		// Let them ask for another code straight away
+		if _, err := db.Exec("DELETE FROM otp WHERE phone = $1", req.Phone); err != nil {
+			slog.Error("Failed to delete unsent OTP", slog.Any("error", err))
+		}
+		return "", "", "There was a problem sending the verification code. Please try again later"
+	}
 
-	slog.Info("Verification code sent", slog.String("phone", req.Phone), slog.Int("code", code))
+	slog.Info("Verification code sent", slog.String("phone", req.Phone))
 
 	return req.Phone, "Check your messages for a verification code", ""
 }
 
 type VerificationRequest struct {
 	Phone string `form:"phone"`
-	Code  int    `form:"otp"`
+	Code  string `form:"otp"`
 }
 
-func VerifyUser(c *gin.Context, db *sql.DB) (string, string) {
+func VerifyUser(c *gin.Context, db *sql.DB) (string, string, string) {
 	req := VerificationRequest{}
 
 	if err := c.Bind(&req); err != nil {
 		slog.Error("Error binding request", slog.Any("error", err))
-		return "", "Bad request. Please try again later"
+		return "", "", "Bad request. Please try again later"
 	}
 
-	slog.Info("User phone", slog.String("phone", req.Phone), slog.Int("code", req.Code))
+	phone, err := NormalizePhone(req.Phone)
+	if err != nil {
+		return "", "", "Please enter a valid phone number"
+	}
+	req.Phone = phone
+	req.Code = strings.TrimSpace(req.Code)
 
-	// Retrieve the stored verification code for the given phone number and make sure it's not expired
-	// Assuming the code is valid for 5 minutes
-	row := db.QueryRow("SELECT code FROM otp WHERE phone = $1 AND created_at > NOW() - INTERVAL '5 minutes'", req.Phone)
-	var storedCode int
-	if err := row.Scan(&storedCode); err != nil {
-		if err == sql.ErrNoRows {
-			return "", "No verification code found for this phone number"
-		}
-		slog.Error("Error scanning stored code", slog.Any("error", err))
-		return "", "There was a problem verifying your phone number. Please try again later"
+	slog.Info("User phone", slog.String("phone", req.Phone))
+
+	if wait, err := OTPLockout(db, req.Phone, c.ClientIP()); err != nil {
+		slog.Error("Error checking OTP lockout", slog.Any("error", err))
+		return "", "", "There was a problem verifying your phone number. Please try again later"
+	} else if wait > 0 {
+		return "", "", lockoutMessage(wait)
 	}
-	if storedCode != req.Code {
-		return "", "Invalid verification code"
+
+	// Codes are single use and only valid for OTPLifetime
+	ok, err := ConsumeOTP(db, req.Phone, req.Code, c.ClientIP())
+	if err != nil {
+		slog.Error("Error checking verification code", slog.Any("error", err))
+		return "", "", "There was a problem verifying your phone number. Please try again later"
+	}
+	if !ok {
+		return "", "", "Invalid or expired verification code"
 	}
 
 	// If the user exists with this phone number, retrieve their details
 	// Otherwise, create a new user with a random username
-	row = db.QueryRow("SELECT id, username, invite_level FROM users WHERE phone = $1", req.Phone)
+	row := db.QueryRow("SELECT id, username, invite_level FROM users WHERE phone = $1", req.Phone)
 	claims := Claims{}
 	if err := row.Scan(&claims.ID, &claims.Username, &claims.InviteLevel); err != nil {
 		if err == sql.ErrNoRows {
 			// Create a new user with a random username
 			claims.Username = petname.Generate(2, "-")
 			_, err := db.Exec("INSERT INTO users (phone, username) VALUES ($1, $2)", req.Phone, claims.Username)
-			row := db.QueryRow("SELECT id FROM users WHERE phone = $1", req.Phone)
-			if err := row.Scan(&claims.ID); err != nil {
+			row := db.QueryRow("SELECT id, invite_level FROM users WHERE phone = $1", req.Phone)
+			if err := row.Scan(&claims.ID, &claims.InviteLevel); err != nil {
 				slog.Error("Error creating new user", slog.Any("error", err))
-				return "", "There was a sigining into your account. Please log in again"
+				return "", "", "There was a sigining into your account. Please log in again"
 			}
 			if err != nil {
 				slog.Error("Error creating new user", slog.Any("error", err))
-				return "", "There was a problem creating your account. Please try again later"
+				return "", "", "There was a problem creating your account. Please try again later"
 			}
+			RecordReferral(c, db, claims.ID, req.Phone)
 		} else {
 			slog.Error("Error scanning user details", slog.Any("error", err))
-			return "", "There was a problem logging in to your account. Please try again later"
+			return "", "", "There was a problem logging in to your account. Please try again later"
 		}
 	}
 
+	claims.Roles, err = GetUserRoles(db, claims.ID)
+	if err != nil {
+		slog.Error("Error loading user roles", slog.Any("error", err))
+		return "", "", "There was a problem signing in. Please try again later"
+	}
+
+	accessToken, refreshToken, err := CreateSession(c, db, claims)
+	if err != nil {
+		slog.Error("Error creating session", slog.Any("error", err))
+		return "", "", "There was a problem signing in. Please try again later"
+	}
+
+	return accessToken, refreshToken, ""
+}
+
+// Sign the claims into the value of the access token cookie
+func IssueToken(claims Claims) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":        claims.ID,
 		"username":  claims.Username,
-		"invitelvl": claims.InviteLevel, // Default invite level
-		"exp":       time.Now().Add(time.Hour * 24 * 365).Unix(),
+		"invitelvl": claims.InviteLevel,
+		"sid":       claims.SessionID,
+		"roles":     claims.Roles,
+		"exp":       time.Now().Add(auth.AccessTokenLifetime).Unix(),
 	})
 
 	secretKey := []byte(os.Getenv("SECRET"))
 	tokenString, err := token.SignedString(secretKey)
 	if err != nil {
-		slog.Error("Error signing token", slog.Any("error", err))
-		return "", "There was a problem signing in. Please try again later"
+		return "", err
 	}
 
-	return "Bearer " + tokenString, ""
+	return "Bearer " + tokenString, nil
 }
 
 type Profile struct {
@@ -0,0 +1,75 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Fake writes messages to a writer instead of sending them, for development and tests
+type Fake struct {
+	mu sync.Mutex
+	w  io.Writer
+	// Every message sent, newest last
+	Sent []Message
+}
+
+// Message is a text recorded by Fake
+type Message struct {
+	ID   string
+	To   string
+	Body string
+	At   time.Time
+}
+
+func NewFake(w io.Writer) *Fake {
+	return &Fake{w: w}
+}
+
+// Append messages to a file, handy for reading codes while running locally
+func NewFileSender(path string) (*Fake, error) {
+	if path == "" {
+		path = "sms.log"
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewFake(f), nil
+}
+
+func (f *Fake) Send(ctx context.Context, to, body string) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	msg := Message{ID: "fake-" + uuid.NewString(), To: to, Body: body, At: time.Now()}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Sent = append(f.Sent, msg)
+	if f.w != nil {
+		if _, err := fmt.Fprintf(f.w, "%s SMS to %s: %s\n", msg.At.Format(time.RFC3339), to, body); err != nil {
+			return Result{}, err
+		}
+	}
+	// Nothing will call back with a status, so the message counts as delivered straight away
+	return Result{ProviderID: msg.ID, Status: StatusDelivered}, nil
+}
+
+// Last message sent to a number, false if there was none
+func (f *Fake) Last(to string) (Message, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.Sent) - 1; i >= 0; i-- {
+		if f.Sent[i].To == to {
+			return f.Sent[i], true
+		}
+	}
+	return Message{}, false
+}
@@ -0,0 +1,43 @@
+package sms
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	DefaultAttempts = 3
+	DefaultBackoff  = 500 * time.Millisecond
+)
+
+type retrySender struct {
+	next     Sender
+	attempts int
+	backoff  time.Duration
+}
+
+// WithRetry retries retryable failures, doubling the wait between attempts with some jitter
+func WithRetry(next Sender, attempts int, backoff time.Duration) Sender {
+	return &retrySender{next: next, attempts: max(attempts, 1), backoff: backoff}
+}
+
+func (s *retrySender) Send(ctx context.Context, to, body string) (Result, error) {
+	wait := s.backoff
+	for attempt := 1; ; attempt++ {
+		res, err := s.next.Send(ctx, to, body)
+		if err == nil || !IsRetryable(err) || attempt >= s.attempts {
+			return res, err
+		}
+
+		slog.Warn("Sending SMS failed, retrying", "attempt", attempt, "wait", wait, "error", err)
+		jitter := time.Duration(rand.Int64N(int64(wait)/2 + 1))
+		select {
+		case <-ctx.Done():
+			return res, ctx.Err()
+		case <-time.After(wait + jitter):
+		}
+		wait *= 2
+	}
+}
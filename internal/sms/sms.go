@@ -0,0 +1,77 @@
+// Package sms sends text messages, mostly one time passcodes, through a pluggable provider.
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Delivery status of a message, following the statuses providers report back
+const (
+	StatusPending     = "pending"
+	StatusQueued      = "queued"
+	StatusSent        = "sent"
+	StatusDelivered   = "delivered"
+	StatusUndelivered = "undelivered"
+	StatusFailed      = "failed"
+)
+
+// Sender hands a message to an SMS provider
+type Sender interface {
+	Send(ctx context.Context, to, body string) (Result, error)
+}
+
+// Result is what the provider said when it accepted a message
+type Result struct {
+	// Provider's ID for the message, used to match up status callbacks
+	ProviderID string
+	Status     string
+}
+
+// RetryableError wraps failures worth trying again, like rate limits and provider outages
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string { return e.Err.Error() }
+func (e *RetryableError) Unwrap() error { return e.Err }
+
+func IsRetryable(err error) bool {
+	var r *RetryableError
+	return errors.As(err, &r)
+}
+
+// Build the sender configured by SMS_PROVIDER: "twilio", "file" (writes to SMS_FILE) or "stdout", the default
+func FromEnv() (Sender, error) {
+	var sender Sender
+	switch provider := os.Getenv("SMS_PROVIDER"); provider {
+	case "twilio":
+		t := &Twilio{
+			AccountSID:     os.Getenv("TWILIO_ACCOUNT_SID"),
+			AuthToken:      os.Getenv("TWILIO_AUTH_TOKEN"),
+			From:           os.Getenv("TWILIO_FROM"),
+			BaseURL:        os.Getenv("TWILIO_BASE_URL"),
+			StatusCallback: os.Getenv("SMS_STATUS_CALLBACK_URL"),
+		}
+		if t.AccountSID == "" || t.AuthToken == "" || t.From == "" {
+			return nil, errors.New("twilio needs TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM")
+		}
+		sender = t
+	case "file":
+		f, err := NewFileSender(os.Getenv("SMS_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		sender = f
+	case "", "stdout":
+		sender = NewFake(os.Stdout)
+	default:
+		return nil, fmt.Errorf("unknown SMS_PROVIDER %q", provider)
+	}
+
+	slog.Info("SMS sender configured", "provider", fmt.Sprintf("%T", sender))
+	return WithRetry(sender, DefaultAttempts, DefaultBackoff), nil
+}
@@ -0,0 +1,30 @@
+package sms
+
+import (
+	"strings"
+	"text/template"
+)
+
+// Message templates, rendered with Render
+const (
+	TemplateOTP = "otp"
+)
+
+var templates = template.Must(template.New("sms").Parse(`
+{{ define "otp" }}{{ .Code }} is your Roshamble verification code. It expires in {{ .Minutes }} minutes.{{ end }}
+`))
+
+// OTP is the data for TemplateOTP
+type OTP struct {
+	Code    string
+	Minutes int
+}
+
+// Render a message body from one of the templates
+func Render(name string, data any) (string, error) {
+	var b strings.Builder
+	if err := templates.ExecuteTemplate(&b, name, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
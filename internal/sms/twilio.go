@@ -0,0 +1,120 @@
+package sms
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const twilioBaseURL = "https://api.twilio.com"
+
+// Twilio sends messages through Twilio's REST API, or anything that speaks it
+type Twilio struct {
+	AccountSID string
+	AuthToken  string
+	From       string
+	// Defaults to the Twilio API, override to point at a compatible provider
+	BaseURL string
+	// Where the provider should post delivery status updates, optional
+	StatusCallback string
+	Client         *http.Client
+}
+
+type twilioMessage struct {
+	SID          string `json:"sid"`
+	Status       string `json:"status"`
+	ErrorCode    int    `json:"error_code"`
+	ErrorMessage string `json:"error_message"`
+	// Set instead of the fields above when the request is rejected
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (t *Twilio) Send(ctx context.Context, to, body string) (Result, error) {
+	base := t.BaseURL
+	if base == "" {
+		base = twilioBaseURL
+	}
+	client := t.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", t.From)
+	form.Set("Body", body)
+	if t.StatusCallback != "" {
+		form.Set("StatusCallback", t.StatusCallback)
+	}
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", strings.TrimRight(base, "/"), url.PathEscape(t.AccountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Result{}, err
+	}
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Network trouble, the provider may be back in a moment
+		return Result{}, &RetryableError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	msg := twilioMessage{}
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil && resp.StatusCode < 300 {
+		return Result{}, fmt.Errorf("decoding sms provider response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("sms provider returned %s: %d %s", resp.Status, msg.Code, msg.Message)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return Result{}, &RetryableError{Err: err}
+		}
+		return Result{}, err
+	}
+	if msg.ErrorCode != 0 {
+		return Result{ProviderID: msg.SID, Status: StatusFailed}, fmt.Errorf("sms provider error %d: %s", msg.ErrorCode, msg.ErrorMessage)
+	}
+
+	status := msg.Status
+	if status == "" || status == "accepted" {
+		status = StatusQueued
+	}
+	return Result{ProviderID: msg.SID, Status: status}, nil
+}
+
+// ValidSignature checks the X-Twilio-Signature of a status callback, which signs the full callback
+// URL followed by every posted parameter sorted by name
+func (t *Twilio) ValidSignature(callbackURL string, params url.Values, signature string) bool {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(callbackURL)
+	for _, k := range keys {
+		for _, v := range params[k] {
+			b.WriteString(k)
+			b.WriteString(v)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(t.AuthToken))
+	mac.Write([]byte(b.String()))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
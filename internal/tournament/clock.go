@@ -0,0 +1,104 @@
+package tournament
+
+import (
+	"Roshamble/internal/protocol"
+	"log/slog"
+	"math"
+	"time"
+)
+
+const (
+	DefaultRoundTimeout = 15 * time.Second
+	// Consecutive timed out rounds before a player forfeits the whole game
+	MaxIdleRounds = 2
+)
+
+// Config holds the per tournament settings
+type Config struct {
+	// How long players have to move before the round is forfeited
+	RoundTimeout time.Duration
+	// Swiss unless set
+	Format  Format
+	Ruleset Ruleset
+}
+
+func (c Config) withDefaults() Config {
+	if c.RoundTimeout <= 0 {
+		c.RoundTimeout = DefaultRoundTimeout
+	}
+	if c.Format == nil {
+		c.Format = Swiss{}
+	}
+	c.Ruleset = c.Ruleset.withDefaults()
+	return c
+}
+
+// clockTick counts down every open round and forfeits the ones that have run out of time
+type clockTick struct {
+	now time.Time
+}
+
+func (clockTick) Name() string { return "tick" }
+
+func (c clockTick) apply(t *Tournament, _ string) (any, error) {
+	t.tick(c.now)
+	return nil, nil
+}
+
+// Tick the round clocks every second until the tournament is over
+func (t *Tournament) startClock() {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.done:
+				return
+			case now := <-ticker.C:
+				t.Send(GameCommand{
+					Command: clockTick{now: now},
+				})
+			}
+		}
+	}()
+}
+
+func (t *Tournament) tick(now time.Time) {
+	for gID, game := range t.Games {
+		if game.Finished || game.RoundDeadline.IsZero() {
+			continue
+		}
+		number := game.currentRound()
+		if number < 0 {
+			continue
+		}
+
+		if now.Before(game.RoundDeadline) {
+			countdown := protocol.RoundCountdown{
+				Round:       number,
+				SecondsLeft: int(math.Ceil(game.RoundDeadline.Sub(now).Seconds())),
+				Deadline:    game.RoundDeadline,
+			}
+			game.Player1.Send(GameResponse{Command: protocol.TypeRoundCountdown, GameID: gID, Payload: countdown})
+			game.Player2.Send(GameResponse{Command: protocol.TypeRoundCountdown, GameID: gID, Payload: countdown})
+			continue
+		}
+
+		// Time is up, whoever has not moved forfeits the round
+		round := &game.Rounds[number]
+		if round.Player1Move == "" {
+			round.Player1Move = Forfeit
+			game.Player1Idle++
+		}
+		if round.Player2Move == "" {
+			round.Player2Move = Forfeit
+			game.Player2Idle++
+		}
+		slog.Info("Round timed out", "tournamentID", t.ID, "gameID", gID, "round", number)
+
+		t.settleRound(&game, number)
+		t.Games[gID] = game
+	}
+
+	t.advanceIfMatchOver()
+}
@@ -0,0 +1,166 @@
+package tournament
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Command is something the tournament loop can be asked to do. Commands are only ever applied
+// on the loop's goroutine, so they are free to touch tournament state.
+type Command interface {
+	Name() string
+	apply(t *Tournament, username string) (any, error)
+}
+
+type GameCommand struct {
+	Username string
+	Command  Command
+	// Optional, receives a ReplyOK or ReplyError once the command has been applied
+	Response chan GameResponse
+	// Seq of the client message the command came from, echoed back on the reply
+	Seq int
+}
+
+// Replies sent on GameCommand.Response
+const (
+	ReplyOK    = "commandOk"
+	ReplyError = "commandError"
+)
+
+// MoveCommand plays a move in the sender's current game
+type MoveCommand struct {
+	Move string
+}
+
+// JoinCommand adds a player to the waiting room, or reconnects them if they are already in it
+type JoinCommand struct {
+	Player *Player
+}
+
+// LeaveCommand removes the sender from the waiting room
+type LeaveCommand struct{}
+
+// StartMatchCommand starts the tournament
+type StartMatchCommand struct{}
+
+// EndMatchCommand wraps up the current match and pairs the next one
+type EndMatchCommand struct{}
+
+// CancelCommand calls the tournament off, whether or not it has started
+type CancelCommand struct {
+	Reason string
+}
+
+// RescheduleCommand changes the start date and settings of a tournament that has not started yet
+type RescheduleCommand struct {
+	StartDate time.Time
+	Config    Config
+}
+
+// ResolveGameCommand settles an unfinished game in the current match by hand, an empty winner calls it a draw.
+// Replies with a snapshot of the resolved game.
+type ResolveGameCommand struct {
+	GameID         string
+	WinnerUsername string
+}
+
+// StandingsCommand replies with the tournament's LiveStandings
+type StandingsCommand struct{}
+
+func (MoveCommand) Name() string        { return "move" }
+func (JoinCommand) Name() string        { return "join" }
+func (LeaveCommand) Name() string       { return "leave" }
+func (StartMatchCommand) Name() string  { return "startMatch" }
+func (EndMatchCommand) Name() string    { return "endMatch" }
+func (CancelCommand) Name() string      { return "cancel" }
+func (RescheduleCommand) Name() string  { return "reschedule" }
+func (ResolveGameCommand) Name() string { return "resolveGame" }
+func (StandingsCommand) Name() string   { return "standings" }
+
+func (c MoveCommand) apply(t *Tournament, username string) (any, error) {
+	return nil, t.AcceptPlayerMove(username, c.Move)
+}
+
+func (c JoinCommand) apply(t *Tournament, username string) (any, error) {
+	if c.Player == nil {
+		return nil, errors.New("join needs a player")
+	}
+	return nil, t.JoinWaitingRoom(username, c.Player)
+}
+
+func (LeaveCommand) apply(t *Tournament, username string) (any, error) {
+	// Players are paired from the waiting room, so once it starts nobody can leave it
+	if t.Started {
+		return nil, errors.New("tournament already started")
+	}
+	if _, ok := t.WaitingRoom[username]; !ok {
+		return nil, fmt.Errorf("%s is not in the waiting room", username)
+	}
+	t.LeaveWaitingRoom(username)
+	return nil, nil
+}
+
+func (StartMatchCommand) apply(t *Tournament, _ string) (any, error) {
+	if t.Started {
+		return nil, errors.New("tournament already started")
+	}
+	t.Start()
+	return nil, nil
+}
+
+func (EndMatchCommand) apply(t *Tournament, _ string) (any, error) {
+	if !t.Started {
+		return nil, errors.New("tournament has not started")
+	}
+	t.EndMatch()
+	return nil, nil
+}
+
+func (c CancelCommand) apply(t *Tournament, _ string) (any, error) {
+	return nil, t.cancel(c.Reason)
+}
+
+func (c RescheduleCommand) apply(t *Tournament, _ string) (any, error) {
+	if t.Started {
+		return nil, errors.New("tournament already started")
+	}
+	t.StartDate = c.StartDate
+	t.Config = c.Config.withDefaults()
+	return nil, nil
+}
+
+func (c ResolveGameCommand) apply(t *Tournament, _ string) (any, error) {
+	return t.ResolveGame(c.GameID, c.WinnerUsername)
+}
+
+func (StandingsCommand) apply(t *Tournament, _ string) (any, error) {
+	return t.LiveStandings(), nil
+}
+
+// Apply a command and reply to the sender if they asked for one
+func (t *Tournament) handle(cmd GameCommand) {
+	if cmd.Command == nil {
+		slog.Error("Empty command", "username", cmd.Username)
+		return
+	}
+
+	result, err := cmd.Command.apply(t, cmd.Username)
+	if err != nil {
+		slog.Warn("Command rejected", "command", cmd.Command.Name(), "username", cmd.Username, "error", err)
+	}
+	if cmd.Response == nil {
+		return
+	}
+
+	resp := GameResponse{Command: ReplyOK, Payload: result, Seq: cmd.Seq}
+	if err != nil {
+		resp = GameResponse{Command: ReplyError, Payload: err, Seq: cmd.Seq}
+	}
+	select {
+	case cmd.Response <- resp:
+	default:
+		slog.Warn("Dropping command reply", "command", cmd.Command.Name(), "username", cmd.Username)
+	}
+}
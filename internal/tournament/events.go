@@ -0,0 +1,139 @@
+package tournament
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// Event is published after the tournament loop has changed state. Events carry copies,
+// so subscribers can hold on to them without racing the loop.
+type Event interface {
+	EventType() string
+}
+
+type PlayerJoined struct {
+	Username string
+}
+
+type PlayerLeft struct {
+	Username string
+}
+
+type TournamentStarted struct {
+	Players []string
+}
+
+type GameStarted struct {
+	Game Game
+}
+
+// MoveAccepted does not carry the move so nobody can peek before the round is settled
+type MoveAccepted struct {
+	GameID   string
+	Username string
+	Round    int
+}
+
+type RoundFinished struct {
+	GameID string
+	Number int
+	Round  Round
+}
+
+type GameFinished struct {
+	Game Game
+}
+
+type MatchEnded struct {
+	Match int
+}
+
+type TournamentEnded struct {
+	WinnerUsername string
+	WinnerID       string
+}
+
+type TournamentCancelled struct{}
+
+func (PlayerJoined) EventType() string        { return "playerJoined" }
+func (PlayerLeft) EventType() string          { return "playerLeft" }
+func (TournamentStarted) EventType() string   { return "tournamentStarted" }
+func (GameStarted) EventType() string         { return "gameStarted" }
+func (MoveAccepted) EventType() string        { return "moveAccepted" }
+func (RoundFinished) EventType() string       { return "roundFinished" }
+func (GameFinished) EventType() string        { return "gameFinished" }
+func (MatchEnded) EventType() string          { return "matchEnded" }
+func (TournamentEnded) EventType() string     { return "tournamentEnded" }
+func (TournamentCancelled) EventType() string { return "tournamentCancelled" }
+
+// eventBus fans events out to subscribers without ever blocking the tournament loop
+type eventBus struct {
+	mu     sync.Mutex
+	nextID int
+	subs   map[int]chan Event
+	closed bool
+}
+
+// Subscribe to the tournament's events. The channel is closed when the tournament stops, the
+// returned cancel func is called or the subscriber falls a full buffer behind. A closed channel
+// always means the stream has ended, never that events went missing in between.
+func (t *Tournament) Subscribe(buffer int) (<-chan Event, func()) {
+	b := &t.events
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, buffer)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subs == nil {
+		b.subs = map[int]chan Event{}
+	}
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = ch
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if sub, ok := b.subs[id]; ok {
+			delete(b.subs, id)
+			close(sub)
+		}
+	}
+}
+
+func (t *Tournament) publish(e Event) {
+	b := &t.events
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for id, sub := range b.subs {
+		select {
+		case sub <- e:
+		default:
+			slog.Warn("Dropping slow subscriber", "tournamentID", t.ID, "event", e.EventType())
+			delete(b.subs, id)
+			close(sub)
+		}
+	}
+}
+
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for id, sub := range b.subs {
+		delete(b.subs, id)
+		close(sub)
+	}
+}
+
+// Copy a game so the rounds can be handed to subscribers. Players are still shared,
+// only their usernames and IDs are safe to read outside the loop.
+func (g Game) snapshot() Game {
+	g.Rounds = append([]Round(nil), g.Rounds...)
+	return g
+}
@@ -0,0 +1,219 @@
+package tournament
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Format decides how many matches a tournament runs, who plays whom in each match and who wins.
+// Formats are stateless, everything they need is kept on the players.
+type Format interface {
+	Name() string
+	// Upper bound on the number of matches for a field of players, Over may end the tournament sooner
+	NumMatches(numPlayers int) int
+	// Segment groups players within a match, e.g. by score in Swiss or by bracket in double elimination
+	Segment(p *Player) int
+	// Pair the players for a match. Players are in seed order and the lobby's segments are already filled in.
+	Pair(match int, players []*Player, lobby MatchLobby) []Pairing
+	// Over reports whether the tournament is decided once the given match has been played
+	Over(match int, players []*Player) bool
+	// Standings ranks the players, the first one wins the tournament
+	Standings(players []*Player) []*Player
+}
+
+// Pairing is a game to be played in a match, Player2 is nil for a bye
+type Pairing struct {
+	Player1 *Player
+	Player2 *Player
+}
+
+const (
+	FormatSwiss             = "swiss"
+	FormatSingleElimination = "single_elimination"
+	FormatDoubleElimination = "double_elimination"
+	FormatRoundRobin        = "round_robin"
+)
+
+var formats = map[string]Format{
+	FormatSwiss:             Swiss{},
+	FormatSingleElimination: Elimination{Lives: 1},
+	FormatDoubleElimination: Elimination{Lives: 2},
+	FormatRoundRobin:        RoundRobin{},
+}
+
+// FormatByName looks up a format by the name stored in tournaments.format
+func FormatByName(name string) (Format, error) {
+	if name == "" {
+		return Swiss{}, nil
+	}
+	f, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tournament format %q", name)
+	}
+	return f, nil
+}
+
+// FormatNames lists every format that can be stored in tournaments.format
+func FormatNames() []string {
+	return []string{FormatSwiss, FormatSingleElimination, FormatDoubleElimination, FormatRoundRobin}
+}
+
+func log2Ceil(n int) int {
+	if n < 2 {
+		return 0
+	}
+	return int(math.Ceil(math.Log2(float64(n))))
+}
+
+// Rank by wins, then fewest losses, then draws
+func rankByRecord(players []*Player) []*Player {
+	ranked := append([]*Player(nil), players...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.WinCount != b.WinCount {
+			return a.WinCount > b.WinCount
+		}
+		if a.Losses != b.Losses {
+			return a.Losses < b.Losses
+		}
+		return a.Draws > b.Draws
+	})
+	return ranked
+}
+
+// Elimination knocks players out once they have lost Lives games. Lives 1 is a single
+// elimination bracket, Lives 2 a double elimination bracket with a losers bracket.
+// A drawn game costs nobody a life, both players go through.
+type Elimination struct {
+	Lives int
+}
+
+func (e Elimination) Name() string {
+	if e.Lives == 2 {
+		return FormatDoubleElimination
+	}
+	return FormatSingleElimination
+}
+
+func (e Elimination) NumMatches(numPlayers int) int {
+	// Leave room for drawn games and the grand final
+	return (log2Ceil(numPlayers) + 1) * (e.Lives + 1)
+}
+
+// Segments are the brackets, keyed by lives lost
+func (e Elimination) Segment(p *Player) int {
+	return p.Losses
+}
+
+func (e Elimination) alive(players []*Player) []*Player {
+	alive := []*Player{}
+	for _, p := range players {
+		if p.Losses < e.Lives {
+			alive = append(alive, p)
+		}
+	}
+	return alive
+}
+
+func (e Elimination) Pair(match int, players []*Player, lobby MatchLobby) []Pairing {
+	alive := e.alive(players)
+
+	// Grand final once each bracket is down to its last player
+	if len(alive) == 2 {
+		return []Pairing{{Player1: alive[0], Player2: alive[1]}}
+	}
+
+	pairings := []Pairing{}
+	for lost := 0; lost < e.Lives; lost++ {
+		bracket := []*Player{}
+		for _, p := range alive {
+			if p.Losses == lost {
+				bracket = append(bracket, p)
+			}
+		}
+		// Top seed plays bottom seed. In odd brackets the bye goes to the top seed who has had
+		// the fewest so far, so it moves around instead of carrying one player to the final.
+		if len(bracket)%2 == 1 {
+			bye := 0
+			for i, p := range bracket {
+				if p.Byes() < bracket[bye].Byes() {
+					bye = i
+				}
+			}
+			pairings = append(pairings, Pairing{Player1: bracket[bye]})
+			bracket = append(bracket[:bye:bye], bracket[bye+1:]...)
+		}
+		for i := 0; i < len(bracket)/2; i++ {
+			pairings = append(pairings, Pairing{Player1: bracket[i], Player2: bracket[len(bracket)-1-i]})
+		}
+	}
+	return pairings
+}
+
+func (e Elimination) Over(match int, players []*Player) bool {
+	return len(e.alive(players)) <= 1
+}
+
+func (e Elimination) Standings(players []*Player) []*Player {
+	ranked := rankByRecord(players)
+	// Whoever is still standing goes first
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Losses < e.Lives && ranked[j].Losses >= e.Lives
+	})
+	return ranked
+}
+
+// RoundRobin plays everyone against everyone else once
+type RoundRobin struct{}
+
+func (RoundRobin) Name() string { return FormatRoundRobin }
+
+func (RoundRobin) NumMatches(numPlayers int) int {
+	if numPlayers%2 == 1 {
+		return numPlayers
+	}
+	return numPlayers - 1
+}
+
+func (RoundRobin) Segment(p *Player) int {
+	return 0
+}
+
+// Circle method, the first seed stays put while everyone else rotates around them
+func (RoundRobin) Pair(match int, players []*Player, lobby MatchLobby) []Pairing {
+	circle := append([]*Player(nil), players...)
+	if len(circle)%2 == 1 {
+		circle = append(circle, nil)
+	}
+	n := len(circle)
+	if n < 2 {
+		return []Pairing{}
+	}
+
+	rotated := []*Player{circle[0]}
+	for i := 0; i < n-1; i++ {
+		rotated = append(rotated, circle[1+(i+match)%(n-1)])
+	}
+
+	pairings := []Pairing{}
+	for i := 0; i < n/2; i++ {
+		p1, p2 := rotated[i], rotated[n-1-i]
+		if p1 == nil {
+			p1, p2 = p2, nil
+		}
+		if p1 == nil {
+			continue
+		}
+		pairings = append(pairings, Pairing{Player1: p1, Player2: p2})
+	}
+	return pairings
+}
+
+func (RoundRobin) Over(match int, players []*Player) bool {
+	return false
+}
+
+func (RoundRobin) Standings(players []*Player) []*Player {
+	return rankByRecord(players)
+}
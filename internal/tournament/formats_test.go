@@ -0,0 +1,57 @@
+package tournament
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEliminationByes(t *testing.T) {
+	for _, lives := range []int{1, 2} {
+		for name, decide := range deciders {
+			for _, n := range []int{3, 5, 7} {
+				t.Run(fmt.Sprintf("lives %d/%s/%d players", lives, name, n), func(t *testing.T) {
+					format := Elimination{Lives: lives}
+					players := newPlayers(n)
+					seed := map[*Player]int{}
+					for i, p := range players {
+						seed[p] = i
+					}
+					for match := 0; match < format.NumMatches(n); match++ {
+						pairings := format.Pair(match, players, MatchLobby{})
+						for _, p := range pairings {
+							if p.Player2 != nil {
+								continue
+							}
+							// Nobody in the same bracket may have had fewer byes, or an equal number and a higher seed
+							for _, other := range format.alive(players) {
+								if other.Losses != p.Player1.Losses || other == p.Player1 {
+									continue
+								}
+								if other.Byes() < p.Player1.Byes() || other.Byes() == p.Player1.Byes() && seed[other] < seed[p.Player1] {
+									t.Errorf("match %d: bye to %s with %d byes, %s has %d", match, p.Player1.Username, p.Player1.Byes(), other.Username, other.Byes())
+								}
+							}
+						}
+
+						for _, p := range pairings {
+							g := Game{Player1: p.Player1, Player2: p.Player2}
+							if p.Player2 != nil {
+								g.WinnerUsername = decide(p.Player1, p.Player2)
+							}
+							g.RecordResult()
+						}
+						if format.Over(match, players) {
+							break
+						}
+					}
+
+					for _, p := range format.alive(players) {
+						if p.Byes() == len(p.History) {
+							t.Errorf("%s is still standing without having played a game", p.Username)
+						}
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package tournament
+
+import "Roshamble/internal/protocol"
+
+// Builds the players' view of their games for the play socket.
+// slot is 1 or 2 for the player receiving the message.
+
+func (g *Game) opponent(slot int) *Player {
+	if slot == 1 {
+		return g.Player2
+	}
+	return g.Player1
+}
+
+func (g *Game) started(slot int, cfg Config) GameResponse {
+	opponent := ""
+	if p := g.opponent(slot); p != nil {
+		opponent = p.Username
+	}
+	return GameResponse{
+		Command: protocol.TypeGameStarted,
+		GameID:  g.ID,
+		Payload: protocol.GameStarted{
+			Match:        g.Match,
+			Opponent:     opponent,
+			Rounds:       len(g.Rounds),
+			RoundSeconds: int(cfg.RoundTimeout.Seconds()),
+			Moves:        cfg.Ruleset.MoveSet.Moves,
+			SuddenDeath:  cfg.Ruleset.SuddenDeath,
+		},
+	}
+}
+
+func (g *Game) resumed(slot int) GameResponse {
+	opponent := ""
+	if p := g.opponent(slot); p != nil {
+		opponent = p.Username
+	}
+	rounds := []protocol.Round{}
+	for i, round := range g.Rounds {
+		mine, _ := round.moves(slot)
+		if mine == "" {
+			break
+		}
+		rounds = append(rounds, round.view(i, slot))
+	}
+	return GameResponse{
+		Command: protocol.TypeGameResumed,
+		GameID:  g.ID,
+		Payload: protocol.GameResumed{
+			Match:    g.Match,
+			Opponent: opponent,
+			Rounds:   rounds,
+		},
+	}
+}
+
+func (g *Game) roundResponse(command string, number, slot int) GameResponse {
+	resp := GameResponse{
+		Command: command,
+		GameID:  g.ID,
+	}
+	round := g.Rounds[number]
+
+	if command == protocol.TypeMoveAccepted {
+		mine, _ := round.moves(slot)
+		resp.Payload = protocol.MoveAccepted{Round: number, Move: mine}
+		return resp
+	}
+
+	p1wins, p2wins, draws := calculateStandings(g.Rounds[:number+1])
+	result := protocol.GameResult{
+		LastRound: round.view(number, slot),
+		Wins:      p1wins,
+		Losses:    p2wins,
+		Draws:     draws,
+	}
+	if slot == 2 {
+		result.Wins, result.Losses = p2wins, p1wins
+	}
+	resp.Payload = result
+	return resp
+}
+
+// Returns the receiving player's move followed by their opponent's
+func (r Round) moves(slot int) (string, string) {
+	if slot == 1 {
+		return r.Player1Move, r.Player2Move
+	}
+	return r.Player2Move, r.Player1Move
+}
+
+func (r Round) view(number, slot int) protocol.Round {
+	mine, theirs := r.moves(slot)
+	view := protocol.Round{Number: number, Move: mine}
+
+	// Only reveal the opponent's move once the round is settled
+	if mine == "" || theirs == "" {
+		return view
+	}
+	view.OpponentMove = theirs
+	switch r.Winner {
+	case 0:
+		view.Result = protocol.ResultDraw
+	case slot:
+		view.Result = protocol.ResultWin
+	default:
+		view.Result = protocol.ResultLoss
+	}
+	return view
+}
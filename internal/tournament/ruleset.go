@@ -0,0 +1,118 @@
+package tournament
+
+import (
+	"fmt"
+	"slices"
+)
+
+// MoveSet is the moves players pick from and which move beats which
+type MoveSet struct {
+	Name  string
+	Moves []string
+	// Each move mapped to the moves it defeats
+	Beats map[string][]string
+}
+
+var RockPaperScissors = MoveSet{
+	Name:  "rps",
+	Moves: []string{"rock", "paper", "scissors"},
+	Beats: map[string][]string{
+		"rock":     {"scissors"},
+		"paper":    {"rock"},
+		"scissors": {"paper"},
+	},
+}
+
+var RockPaperScissorsLizardSpock = MoveSet{
+	Name:  "rpsls",
+	Moves: []string{"rock", "paper", "scissors", "lizard", "spock"},
+	Beats: map[string][]string{
+		"rock":     {"scissors", "lizard"},
+		"paper":    {"rock", "spock"},
+		"scissors": {"paper", "lizard"},
+		"lizard":   {"paper", "spock"},
+		"spock":    {"rock", "scissors"},
+	},
+}
+
+var moveSets = map[string]MoveSet{
+	RockPaperScissors.Name:            RockPaperScissors,
+	RockPaperScissorsLizardSpock.Name: RockPaperScissorsLizardSpock,
+}
+
+// MoveSetByName looks up a move set by the name stored in tournaments.move_set
+func MoveSetByName(name string) (MoveSet, error) {
+	if name == "" {
+		return RockPaperScissors, nil
+	}
+	ms, ok := moveSets[name]
+	if !ok {
+		return MoveSet{}, fmt.Errorf("unknown move set %q", name)
+	}
+	return ms, nil
+}
+
+// MoveSetNames lists every move set that can be stored in tournaments.move_set
+func MoveSetNames() []string {
+	return []string{RockPaperScissors.Name, RockPaperScissorsLizardSpock.Name}
+}
+
+func (ms MoveSet) Valid(move string) bool {
+	return slices.Contains(ms.Moves, move)
+}
+
+// Extra rounds sudden death will play before calling a game a draw
+const MaxSuddenDeathRounds = 5
+
+// Ruleset describes how a single game is played
+type Ruleset struct {
+	// Rounds in a game, the first to win a majority takes it
+	BestOf int
+	// Keep playing single rounds after a tied game until someone wins one
+	SuddenDeath bool
+	MoveSet     MoveSet
+}
+
+var DefaultRuleset = Ruleset{
+	BestOf:  5,
+	MoveSet: RockPaperScissors,
+}
+
+func (r Ruleset) withDefaults() Ruleset {
+	if r.BestOf <= 0 {
+		r.BestOf = DefaultRuleset.BestOf
+	}
+	if len(r.MoveSet.Moves) == 0 {
+		r.MoveSet = DefaultRuleset.MoveSet
+	}
+	return r
+}
+
+// WinsNeeded to take a game outright
+func (r Ruleset) WinsNeeded() int {
+	return r.BestOf/2 + 1
+}
+
+// Winner of a round, 0 for a draw otherwise the winning player's slot
+func (r Ruleset) Winner(move1, move2 string) int {
+	// Nobody played, both forfeited, or both played the same
+	if move1 == move2 {
+		return 0
+	}
+
+	// Whoever played takes the round
+	if move1 == "" || move1 == Forfeit {
+		return 2
+	}
+	if move2 == "" || move2 == Forfeit {
+		return 1
+	}
+
+	if slices.Contains(r.MoveSet.Beats[move1], move2) {
+		return 1
+	}
+	if slices.Contains(r.MoveSet.Beats[move2], move1) {
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,132 @@
+package tournament
+
+import (
+	"Roshamble/internal/protocol"
+	"sort"
+)
+
+// Builds the spectators' view of the tournament. Spectators see both players of every game
+// but never a move until the round has been committed to by both.
+
+// How many events a spectator can fall behind by before their stream is closed. Their socket
+// goes with it and the page reconnects for a fresh snapshot.
+const spectatorBuffer = 64
+
+// SpectateCommand subscribes to the tournament's events and replies with a Spectation. Both are
+// done on the loop, so nothing is missed or seen twice between the snapshot and the stream.
+type SpectateCommand struct{}
+
+// Spectation is a spectator's starting point and their event stream. Cancel must be called
+// once they leave.
+type Spectation struct {
+	Snapshot protocol.Snapshot
+	Events   <-chan Event
+	Cancel   func()
+}
+
+func (SpectateCommand) Name() string { return "spectate" }
+
+func (SpectateCommand) apply(t *Tournament, _ string) (any, error) {
+	events, cancel := t.Subscribe(spectatorBuffer)
+	return Spectation{Snapshot: t.snapshot(), Events: events, Cancel: cancel}, nil
+}
+
+func (t *Tournament) snapshot() protocol.Snapshot {
+	snap := protocol.Snapshot{
+		Started:   t.Started,
+		Match:     t.CurMatch,
+		Standings: []protocol.Standing{},
+		Segments:  []protocol.Segment{},
+		Games:     map[string]protocol.SpectatedGame{},
+	}
+	for _, s := range t.LiveStandings().Players {
+		snap.Standings = append(snap.Standings, protocol.Standing(s))
+	}
+	if t.Started && t.CurMatch < len(t.MatchLobbies) {
+		for segment, players := range t.MatchLobbies[t.CurMatch].Segments {
+			s := protocol.Segment{Segment: segment, Players: []string{}}
+			for _, p := range players {
+				s.Players = append(s.Players, p.Username)
+			}
+			snap.Segments = append(snap.Segments, s)
+		}
+		sort.Slice(snap.Segments, func(i, j int) bool { return snap.Segments[i].Segment < snap.Segments[j].Segment })
+	}
+	for id, game := range t.Games {
+		snap.Games[id] = game.spectated()
+	}
+	return snap
+}
+
+func (r Round) committed() bool {
+	return r.Player1Move != "" && r.Player2Move != ""
+}
+
+func (r Round) spectated(number int) protocol.SpectatedRound {
+	return protocol.SpectatedRound{Number: number, Player1Move: r.Player1Move, Player2Move: r.Player2Move, Winner: r.Winner}
+}
+
+func (g Game) spectated() protocol.SpectatedGame {
+	sg := protocol.SpectatedGame{
+		Match:    g.Match,
+		Rounds:   []protocol.SpectatedRound{},
+		Finished: g.Finished,
+		Winner:   g.WinnerUsername,
+	}
+	if g.Player1 != nil {
+		sg.Player1 = g.Player1.Username
+	}
+	if g.Player2 != nil {
+		sg.Player2 = g.Player2.Username
+	}
+
+	for i, round := range g.Rounds {
+		if !round.committed() {
+			// Only whether they have moved, never what
+			if !g.Finished {
+				sg.Player1Moved = round.Player1Move != ""
+				sg.Player2Moved = round.Player2Move != ""
+			}
+			break
+		}
+		sg.Rounds = append(sg.Rounds, round.spectated(i))
+		switch round.Winner {
+		case 1:
+			sg.Player1Wins++
+		case 2:
+			sg.Player2Wins++
+		}
+	}
+	return sg
+}
+
+// SpectatorMessage turns an event into the message sent to spectators. Events spectators
+// are not shown return an empty type.
+func SpectatorMessage(e Event) (msgType, gameID string, payload any) {
+	switch e := e.(type) {
+	case PlayerJoined:
+		return protocol.TypePlayerJoined, "", protocol.SpectatedPlayer{Username: e.Username}
+	case PlayerLeft:
+		return protocol.TypePlayerLeft, "", protocol.SpectatedPlayer{Username: e.Username}
+	case TournamentStarted:
+		return protocol.TypeTournamentStarted, "", protocol.TournamentStarted{Players: e.Players}
+	case GameStarted:
+		return protocol.TypeGamePaired, e.Game.ID, e.Game.spectated()
+	case MoveAccepted:
+		return protocol.TypeMoveCommitted, e.GameID, protocol.MoveCommitted{Player: e.Username, Round: e.Round}
+	case RoundFinished:
+		if !e.Round.committed() {
+			return "", "", nil
+		}
+		return protocol.TypeRoundRevealed, e.GameID, e.Round.spectated(e.Number)
+	case GameFinished:
+		return protocol.TypeGameFinished, e.Game.ID, e.Game.spectated()
+	case MatchEnded:
+		return protocol.TypeMatchEnded, "", protocol.MatchEnded{Match: e.Match}
+	case TournamentEnded:
+		return protocol.TypeTournamentEnded, "", protocol.TournamentEnded{Winner: e.WinnerUsername}
+	case TournamentCancelled:
+		return protocol.TypeTournamentCancelled, "", protocol.TournamentCancelled{}
+	}
+	return "", "", nil
+}
@@ -0,0 +1,141 @@
+package tournament
+
+import "sort"
+
+// Swiss plays everyone every match, pairing players on the same score. Nobody meets the same
+// opponent twice or gets more than one bye while it can be avoided, players left over in a
+// score group float down to the next one.
+type Swiss struct{}
+
+// Give up on a perfect pairing after this many tries and allow rematches instead
+const maxPairingSteps = 100_000
+
+func (Swiss) Name() string { return FormatSwiss }
+
+func (Swiss) NumMatches(numPlayers int) int {
+	return log2Ceil(numPlayers) + 1
+}
+
+func (Swiss) Segment(p *Player) int {
+	return p.Points()
+}
+
+func (s Swiss) Pair(match int, players []*Player, lobby MatchLobby) []Pairing {
+	// Highest score first, seed order within a score group
+	ranked := append([]*Player(nil), players...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Points() > ranked[j].Points()
+	})
+
+	pairings := []Pairing{}
+
+	// The bye goes to the lowest ranked player who has not had one yet
+	if len(ranked)%2 == 1 {
+		bye := len(ranked) - 1
+		for i := len(ranked) - 1; i >= 0; i-- {
+			if ranked[i].Byes() == 0 {
+				bye = i
+				break
+			}
+		}
+		pairings = append(pairings, Pairing{Player1: ranked[bye]})
+		ranked = append(ranked[:bye:bye], ranked[bye+1:]...)
+	}
+
+	steps := 0
+	if pairs, ok := pairWithoutRematches(ranked, &steps); ok {
+		return append(pairings, pairs...)
+	}
+
+	// Everyone has played everyone they could, fall back to pairing down the standings
+	for i := 0; i+1 < len(ranked); i += 2 {
+		pairings = append(pairings, Pairing{Player1: ranked[i], Player2: ranked[i+1]})
+	}
+	return pairings
+}
+
+// Pair the top player with the next highest player they have not met, backtracking when
+// that leaves the rest of the field unpairable. Leftovers float down naturally since
+// candidates are tried in ranking order.
+func pairWithoutRematches(ranked []*Player, steps *int) ([]Pairing, bool) {
+	if len(ranked) == 0 {
+		return []Pairing{}, true
+	}
+	*steps++
+	if *steps > maxPairingSteps {
+		return nil, false
+	}
+
+	top := ranked[0]
+	for i := 1; i < len(ranked); i++ {
+		if top.HasPlayed(ranked[i].Username) {
+			continue
+		}
+		rest := make([]*Player, 0, len(ranked)-2)
+		rest = append(rest, ranked[1:i]...)
+		rest = append(rest, ranked[i+1:]...)
+		if pairs, ok := pairWithoutRematches(rest, steps); ok {
+			return append([]Pairing{{Player1: top, Player2: ranked[i]}}, pairs...), true
+		}
+	}
+	return nil, false
+}
+
+func (Swiss) Over(match int, players []*Player) bool {
+	return false
+}
+
+// Standings by score, then Buchholz, then Sonneborn-Berger, then head to head
+func (Swiss) Standings(players []*Player) []*Player {
+	byName := map[string]*Player{}
+	for _, p := range players {
+		byName[p.Username] = p
+	}
+
+	buchholz := map[*Player]int{}
+	sonnebornBerger := map[*Player]int{}
+	for _, p := range players {
+		for _, r := range p.History {
+			opp, ok := byName[r.Opponent]
+			if !ok {
+				continue
+			}
+			// Sum of every opponent's score
+			buchholz[p] += opp.Points()
+			// Sum of the scores of opponents beaten, half for opponents drawn
+			switch r.Outcome {
+			case Win:
+				sonnebornBerger[p] += 2 * opp.Points()
+			case Draw:
+				sonnebornBerger[p] += opp.Points()
+			}
+		}
+	}
+
+	ranked := append([]*Player(nil), players...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.Points() != b.Points() {
+			return a.Points() > b.Points()
+		}
+		if buchholz[a] != buchholz[b] {
+			return buchholz[a] > buchholz[b]
+		}
+		if sonnebornBerger[a] != sonnebornBerger[b] {
+			return sonnebornBerger[a] > sonnebornBerger[b]
+		}
+		return headToHead(a, b) > 0
+	})
+	return ranked
+}
+
+// Net result of a's games against b
+func headToHead(a, b *Player) int {
+	net := 0
+	for _, r := range a.History {
+		if r.Opponent == b.Username {
+			net += int(r.Outcome)
+		}
+	}
+	return net
+}
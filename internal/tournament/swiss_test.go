@@ -0,0 +1,130 @@
+package tournament
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newPlayers(n int) []*Player {
+	players := make([]*Player, n)
+	for i := range players {
+		players[i] = &Player{Username: fmt.Sprintf("p%d", i+1)}
+	}
+	return players
+}
+
+// Decides the winner of a game, an empty winner is a draw
+type decider func(p1, p2 *Player) string
+
+var deciders = map[string]decider{
+	"player1 wins": func(p1, p2 *Player) string { return p1.Username },
+	"player2 wins": func(p1, p2 *Player) string { return p2.Username },
+	"draws":        func(p1, p2 *Player) string { return "" },
+	"lower name wins": func(p1, p2 *Player) string {
+		if p1.Username < p2.Username {
+			return p1.Username
+		}
+		return p2.Username
+	},
+}
+
+func TestSwissPairing(t *testing.T) {
+	for name, decide := range deciders {
+		for n := 2; n <= 8; n++ {
+			t.Run(fmt.Sprintf("%s/%d players", name, n), func(t *testing.T) {
+				players := newPlayers(n)
+				for match := 0; match < log2Ceil(n); match++ {
+					pairings := Swiss{}.Pair(match, players, MatchLobby{})
+
+					seen := map[string]bool{}
+					for _, p := range pairings {
+						for _, player := range []*Player{p.Player1, p.Player2} {
+							if player == nil {
+								continue
+							}
+							if seen[player.Username] {
+								t.Fatalf("match %d: %s paired twice", match, player.Username)
+							}
+							seen[player.Username] = true
+						}
+						if p.Player2 != nil && p.Player1.HasPlayed(p.Player2.Username) {
+							t.Errorf("match %d: rematch between %s and %s", match, p.Player1.Username, p.Player2.Username)
+						}
+					}
+					if len(seen) != n {
+						t.Fatalf("match %d: paired %d of %d players", match, len(seen), n)
+					}
+
+					for _, p := range pairings {
+						g := Game{Player1: p.Player1, Player2: p.Player2}
+						if p.Player2 != nil {
+							g.WinnerUsername = decide(p.Player1, p.Player2)
+						}
+						g.RecordResult()
+					}
+				}
+
+				for _, p := range players {
+					if p.Byes() > 1 {
+						t.Errorf("%s had %d byes", p.Username, p.Byes())
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSwissStandings(t *testing.T) {
+	tests := []struct {
+		name string
+		// Player order going in
+		players []string
+		// Player1, player2 and the winner, an empty winner is a draw
+		games [][3]string
+		want  []string
+	}{
+		{
+			name:    "points first",
+			players: []string{"a", "b", "c"},
+			games:   [][3]string{{"a", "c", "c"}, {"b", "c", "c"}, {"a", "b", ""}},
+			want:    []string{"c", "a", "b"},
+		},
+		{
+			// a and c have beaten each other's only other opponent, b has only beaten d
+			name:    "buchholz then sonneborn-berger",
+			players: []string{"d", "b", "c", "a"},
+			games:   [][3]string{{"a", "c", "a"}, {"b", "d", "b"}, {"c", "d", "c"}},
+			want:    []string{"a", "c", "b", "d"},
+		},
+		{
+			// a and b are level on everything until their own game
+			name:    "head to head",
+			players: []string{"z", "x", "y", "b", "a"},
+			games:   [][3]string{{"a", "b", "a"}, {"y", "a", "y"}, {"b", "x", "b"}, {"x", "z", "x"}},
+			want:    []string{"a", "b", "y", "x", "z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			byName := map[string]*Player{}
+			players := []*Player{}
+			for _, name := range tt.players {
+				byName[name] = &Player{Username: name}
+				players = append(players, byName[name])
+			}
+			for _, g := range tt.games {
+				game := Game{Player1: byName[g[0]], Player2: byName[g[1]], WinnerUsername: g[2]}
+				game.RecordResult()
+			}
+
+			got := []string{}
+			for _, p := range (Swiss{}).Standings(players) {
+				got = append(got, p.Username)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package tournament
 
 import (
+	"Roshamble/internal/protocol"
+	"errors"
+	"fmt"
 	"log/slog"
-	"math"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,11 +17,30 @@ type Tournament struct {
 	MatchLobbies   []MatchLobby
 	WaitingRoom    map[string]*Player
 	Games          map[string]Game
+	PlayerGames    map[string]string // username -> ID of their game in the current match
 	CurMatch       int
 	WinnerUsername string
 	CommandChan    chan GameCommand
 	StartDate      time.Time
 	Started        bool
+	Store          Store
+	Config         Config
+
+	done     chan struct{}
+	stopOnce sync.Once
+	events   eventBus
+}
+
+// Store persists games and rounds as they are played so results survive restarts
+type Store interface {
+	CreateGame(tournamentID int, game Game) error
+	SaveRound(gameID string, number int, round Round) error
+	FinishGame(game Game) error
+	StartTournament(tournamentID int) error
+	FinishTournament(tournamentID int, winnerID string) error
+	CancelTournament(tournamentID int) error
+	// Players whose registration is confirmed, in the order they registered
+	ConfirmedPlayers(tournamentID int) ([]*Player, error)
 }
 
 type MatchLobby struct {
@@ -26,10 +49,18 @@ type MatchLobby struct {
 }
 
 type Game struct {
+	ID             string
+	Match          int
 	Rounds         []Round
 	Player1        *Player
 	Player2        *Player
 	WinnerUsername string
+	Finished       bool
+	// When the current round is forfeited, zero once the game is finished
+	RoundDeadline time.Time
+	// Consecutive rounds each player has let time out on
+	Player1Idle int
+	Player2Idle int
 }
 
 type Round struct {
@@ -39,39 +70,187 @@ type Round struct {
 }
 
 type Player struct {
+	ID       string
 	Username string
 	MsgChan  chan GameResponse
 	WinCount int
+	Losses   int
+	Draws    int
+	// Every finished game in match order
+	History []Result
 }
 
-type GameCommand struct {
-	Username string
-	Command  string
-	Payload  any
-	Response chan GameResponse
+// Result of a finished game from one player's point of view
+type Result struct {
+	// Empty for a bye
+	Opponent string
+	Outcome  Outcome
+}
+
+type Outcome int
+
+const (
+	Loss Outcome = -1
+	Draw Outcome = 0
+	Win  Outcome = 1
+)
+
+// Points scores a player's record, 2 for a win and 1 for a draw so half points stay whole
+func (p *Player) Points() int {
+	return 2*p.WinCount + p.Draws
+}
+
+// Byes counts the matches a player sat out with a bye
+func (p *Player) Byes() int {
+	byes := 0
+	for _, r := range p.History {
+		if r.Opponent == "" {
+			byes++
+		}
+	}
+	return byes
+}
+
+// HasPlayed reports whether the player has already faced an opponent
+func (p *Player) HasPlayed(opponent string) bool {
+	for _, r := range p.History {
+		if r.Opponent == opponent {
+			return true
+		}
+	}
+	return false
+}
+
+// Send a response to the player without blocking the tournament on a slow or disconnected socket
+func (p *Player) Send(resp GameResponse) {
+	if p == nil || p.MsgChan == nil {
+		return
+	}
+	select {
+	case p.MsgChan <- resp:
+	default:
+		slog.Warn("Dropping message for unresponsive player", "username", p.Username, "command", resp.Command)
+	}
 }
 
+// GameResponse is sent from the tournament to a player, Command is one of the protocol message types
 type GameResponse struct {
 	Command string
+	GameID  string
 	Payload any
+	// Only set on ReplyOK and ReplyError, the Seq of the command being replied to
+	Seq int
 }
 
-func NewTournament(id int) *Tournament {
+func NewTournament(id int, startDate time.Time, store Store, cfg Config) *Tournament {
 	cmdChan := make(chan GameCommand, 100)
 	t := &Tournament{
 		ID:             id,
 		MatchLobbies:   []MatchLobby{},
 		WaitingRoom:    map[string]*Player{},
 		Games:          map[string]Game{},
+		PlayerGames:    map[string]string{},
 		CurMatch:       0,
 		WinnerUsername: "",
 		CommandChan:    cmdChan,
+		StartDate:      startDate,
+		Store:          store,
+		Config:         cfg.withDefaults(),
+		done:           make(chan struct{}),
 	}
 
 	go t.Listen()
 	return t
 }
 
+// Snapshot is the persisted state of a running tournament, used to rebuild it after a restart
+type Snapshot struct {
+	ID        int
+	StartDate time.Time
+	Config    Config
+	CurMatch  int
+	// Players with their win counts from every match before CurMatch
+	Players []*Player
+	// Games of the current match, with players pointing into Players
+	Games []Game
+}
+
+// Restore rebuilds a running tournament from its persisted state and picks up the current match where it left off
+func Restore(snap Snapshot, store Store) *Tournament {
+	t := &Tournament{
+		ID:           snap.ID,
+		MatchLobbies: []MatchLobby{},
+		WaitingRoom:  map[string]*Player{},
+		Games:        map[string]Game{},
+		PlayerGames:  map[string]string{},
+		CurMatch:     snap.CurMatch,
+		CommandChan:  make(chan GameCommand, 100),
+		StartDate:    snap.StartDate,
+		Started:      true,
+		Store:        store,
+		Config:       snap.Config.withDefaults(),
+		done:         make(chan struct{}),
+	}
+
+	for _, player := range snap.Players {
+		t.WaitingRoom[player.Username] = player
+	}
+
+	numMatches := t.Config.Format.NumMatches(len(t.WaitingRoom))
+	for i := range max(numMatches, t.CurMatch+1) {
+		t.MatchLobbies = append(t.MatchLobbies, MatchLobby{
+			Level:    i,
+			Segments: map[int][]*Player{},
+		})
+	}
+	for _, game := range snap.Games {
+		for _, player := range []*Player{game.Player1, game.Player2} {
+			if player != nil {
+				segment := t.Config.Format.Segment(player)
+				t.MatchLobbies[t.CurMatch].Segments[segment] = append(t.MatchLobbies[t.CurMatch].Segments[segment], player)
+			}
+		}
+	}
+
+	// Everyone gets a fresh clock on the round they were playing
+	for _, game := range snap.Games {
+		if !game.Finished {
+			game.RoundDeadline = time.Now().Add(t.Config.RoundTimeout)
+		}
+		t.Games[game.ID] = game
+	}
+	t.indexGames()
+
+	slog.Info("Tournament restored", "tournamentID", t.ID, "match", t.CurMatch, "numPlayers", len(t.WaitingRoom), "numGames", len(t.Games))
+
+	go t.Listen()
+	t.startClock()
+	return t
+}
+
+// Done is closed once the tournament has finished or been cancelled
+func (t *Tournament) Done() <-chan struct{} {
+	return t.done
+}
+
+// Send queues a command for the tournament, dropping it if the tournament is already over
+func (t *Tournament) Send(cmd GameCommand) bool {
+	select {
+	case t.CommandChan <- cmd:
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+// Stop the command loop and every ticker owned by the tournament
+func (t *Tournament) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+		t.events.close()
+	})
+}
+
 // Listen for game commands
 func (t *Tournament) Listen() {
 	ticker := time.NewTicker(5 * time.Second)
@@ -81,14 +260,15 @@ func (t *Tournament) Listen() {
 	go func(ticker *time.Ticker) {
 		for {
 			select {
+			case <-t.done:
+				return
 			case <-ticker.C:
 				if time.Now().After(t.StartDate) && !t.Started {
 					slog.Info("Tournament start date has passed, starting tournament")
 
-					t.CommandChan <- GameCommand{
-						Command:  "startMatch",
-						Response: nil,
-					}
+					t.Send(GameCommand{
+						Command: StartMatchCommand{},
+					})
 				}
 			}
 		}
@@ -98,21 +278,10 @@ func (t *Tournament) Listen() {
 	// Listen for commands
 	for {
 		select {
+		case <-t.done:
+			return
 		case cmd := <-t.CommandChan:
-			switch cmd.Command {
-			case "move":
-				t.AcceptPlayerMove(cmd.Username, cmd.Command)
-			case "join":
-				t.JoinWaitingRoom(cmd.Username, cmd.Payload.(*Player))
-			case "leave":
-				t.LeaveWaitingRoom(cmd.Username)
-			case "startMatch":
-				t.Start()
-			case "endMatch":
-				t.EndMatch()
-			default:
-				slog.Error("Unknown command", "command", cmd.Command)
-			}
+			t.handle(cmd)
 		}
 	}
 }
@@ -120,365 +289,521 @@ func (t *Tournament) Listen() {
 // Contains the logic to start the tournament, and also the go routine to check the every 20 seconds
 func (t *Tournament) Start() {
 	t.Started = true
-	// Calculate num of matches in swiss format and create matches lobbies
-	numMatches := math.Ceil(math.Log2(float64(len(t.WaitingRoom))))
-	for i := range int(numMatches) + 1 {
+	t.seedWaitingRoom()
+
+	// Nobody to play against, call it off
+	if len(t.WaitingRoom) < 2 {
+		slog.Info("Not enough players to start tournament, cancelling", "tournamentID", t.ID, "numPlayers", len(t.WaitingRoom))
+		if err := t.cancel("Not enough players joined"); err != nil {
+			slog.Error("Error cancelling tournament", "tournamentID", t.ID, "error", err)
+		}
+		return
+	}
+
+	if err := t.Store.StartTournament(t.ID); err != nil {
+		slog.Error("Error marking tournament as running", "tournamentID", t.ID, "error", err)
+	}
+
+	// Create a lobby for every match the format might need
+	numMatches := t.Config.Format.NumMatches(len(t.WaitingRoom))
+	t.MatchLobbies = []MatchLobby{}
+	for i := range max(numMatches, 1) {
 		t.MatchLobbies = append(t.MatchLobbies, MatchLobby{
 			Level:    i,
 			Segments: map[int][]*Player{},
 		})
 	}
-
-	// Add players to the first match
-	// The first match only has 1 segment of 0 wins
-	t.MatchLobbies[0].Segments[0] = []*Player{}
-	for _, player := range t.WaitingRoom {
-		t.MatchLobbies[0].Segments[0] = append(t.MatchLobbies[0].Segments[0], player)
-	}
-
-	// Create games for first Match
 	t.CurMatch = 0
 
-	// I guess the last player will be a bye in odd pairings
-	// since the player will be nil gotta be careful not to deref them
-	gameID := uuid.New().String()
-	for i := range t.MatchLobbies[0].Segments[0] {
-		if i%2 == 0 {
-			t.Games[gameID] = Game{
-				Rounds:  make([]Round, 5),
-				Player1: t.MatchLobbies[0].Segments[0][i],
-				Player2: nil,
-			}
-		} else {
-			game, ok := t.Games[gameID]
-			if !ok {
-				slog.Error("Game not found during matchmaking! Huge problem!", "gameID", gameID)
-				gameID = uuid.New().String()
-				continue
-			}
-			game.Player2 = t.MatchLobbies[0].Segments[0][i]
-			t.Games[gameID] = game
-			gameID = uuid.New().String()
-		}
+	players := []string{}
+	for username := range t.WaitingRoom {
+		players = append(players, username)
 	}
+	t.publish(TournamentStarted{Players: players})
+	slog.Info("Tournament started", "format", t.Config.Format.Name(), "numMatches", numMatches, "numPlayers", len(t.WaitingRoom))
 
-	// Alert players in games
-	for _, game := range t.Games {
-		if game.Player1 != nil {
-			game.Player1.MsgChan <- GameResponse{
-				Command: "gameStarted",
-				Payload: game,
-			}
-		}
-		if game.Player2 != nil {
-			game.Player2.MsgChan <- GameResponse{
-				Command: "gameStarted",
-				Payload: game,
-			}
-		}
+	t.startClock()
+	t.pairMatch()
+}
+
+// Call the tournament off and let everyone in it know. Players are told and the loop is stopped even if the store fails.
+func (t *Tournament) cancel(reason string) error {
+	err := t.Store.CancelTournament(t.ID)
+	for _, player := range t.WaitingRoom {
+		player.Send(GameResponse{
+			Command: protocol.TypeTournamentCancelled,
+			Payload: protocol.TournamentCancelled{Reason: reason},
+		})
 	}
-	slog.Info("Tournament started", "numMatches", numMatches, "numPlayers", len(t.WaitingRoom))
-	go func() {
-		ticker := time.NewTicker(20 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				slog.Info("Checking for game results")
-				t.EndMatch()
-			}
-		}
-	}()
+	t.publish(TournamentCancelled{})
+	t.Stop()
+	return err
 }
 
-// Start check every 20 seconds wrap up all games.
+// Wrap up the current match and pair the next one, or finish the tournament after the last match
 func (t *Tournament) EndMatch() {
 	slog.Info("Locking tournament to check games")
 
 	// Wrap up all games
 	// increment win count for each winner
-	for _, game := range t.Games {
-		username := game.CalculateWinner()
-		if username == "" {
-			continue
+	for gID, game := range t.Games {
+		alreadyFinished := game.Finished
+		game.CalculateWinner(t.Config.Ruleset)
+
+		// Games that finished during the match were written and rated as they finished
+		if !alreadyFinished {
+			if err := t.Store.FinishGame(game); err != nil {
+				slog.Error("Error persisting finished game", "gameID", gID, "error", err)
+			}
+			t.publish(GameFinished{Game: game.snapshot()})
 		}
-		// Update winner's win count
-		wp := t.WaitingRoom[username]
-		wp.WinCount++
-		t.WaitingRoom[username] = wp
+		// The result is in the database now, so the game no longer needs to live in memory
+		delete(t.Games, gID)
+
+		// Update everyone's record
+		game.RecordResult()
 	}
 
+	t.publish(MatchEnded{Match: t.CurMatch})
+
 	t.CurMatch++
-	if t.CurMatch >= len(t.MatchLobbies) {
-		// Tournament is over determine winner
-		maxWins := 0
+	if t.CurMatch >= len(t.MatchLobbies) || t.Config.Format.Over(t.CurMatch-1, t.players()) {
+		// Tournament is over determine winner, nobody wins if nobody won a game
 		winner := ""
-		for _, player := range t.WaitingRoom {
-			if player.WinCount > maxWins {
-				maxWins = player.WinCount
-				winner = player.Username
-			}
+		if standings := t.Config.Format.Standings(t.players()); len(standings) > 0 && standings[0].WinCount > 0 {
+			winner = standings[0].Username
 		}
 		t.WinnerUsername = winner
 		slog.Info("Tournament ended", "winner", winner)
+
+		winnerID := ""
+		if wp, ok := t.WaitingRoom[winner]; ok {
+			winnerID = wp.ID
+		}
+		if err := t.Store.FinishTournament(t.ID, winnerID); err != nil {
+			slog.Error("Error writing tournament winner", "tournamentID", t.ID, "winner", winner, "error", err)
+		}
+
 		// Alert players of tournament end
 		for _, player := range t.WaitingRoom {
-			player.MsgChan <- GameResponse{
-				Command: "tournamentEnded",
-				Payload: winner,
-			}
+			player.Send(GameResponse{
+				Command: protocol.TypeTournamentEnded,
+				Payload: protocol.TournamentEnded{Winner: winner},
+			})
 		}
+		t.publish(TournamentEnded{WinnerUsername: winner, WinnerID: winnerID})
+		t.Stop()
 		return
-	} else {
-		// Calculate new segments for next match
-		match := t.MatchLobbies[t.CurMatch]
-		match.Segments = make(map[int][]*Player)
-		for _, player := range t.WaitingRoom {
-			match.Segments[player.WinCount] = append(match.Segments[player.WinCount], player)
-		}
-		t.MatchLobbies[t.CurMatch] = match
-		// Create new games for current match
-		for k, players := range t.MatchLobbies[t.CurMatch].Segments {
-			gameID := uuid.New().String()
-			for i := range players {
-				if i%2 == 0 {
-					t.Games[gameID] = Game{
-						Rounds:  make([]Round, 5),
-						Player1: t.MatchLobbies[t.CurMatch].Segments[k][i],
-						Player2: nil,
-					}
-				} else {
-					game, ok := t.Games[gameID]
-					if !ok {
-						slog.Error("Game not found during matchmaking in round! Huge problem!", "gameID", gameID, "round", t.CurMatch)
-						gameID = uuid.New().String()
-						continue
-					}
-					game.Player2 = t.MatchLobbies[t.CurMatch].Segments[k][i]
-					t.Games[gameID] = game
-					gameID = uuid.New().String()
-				}
-			}
+	}
+
+	t.pairMatch()
+}
+
+// Group players into the current match's segments and create the games the format pairs them into
+func (t *Tournament) pairMatch() {
+	players := t.players()
+	lobby := t.MatchLobbies[t.CurMatch]
+	lobby.Segments = map[int][]*Player{}
+	for _, player := range players {
+		segment := t.Config.Format.Segment(player)
+		lobby.Segments[segment] = append(lobby.Segments[segment], player)
+	}
+	t.MatchLobbies[t.CurMatch] = lobby
+
+	for _, pairing := range t.Config.Format.Pair(t.CurMatch, players, lobby) {
+		game := Game{
+			ID:      uuid.New().String(),
+			Match:   t.CurMatch,
+			Rounds:  make([]Round, t.Config.Ruleset.BestOf),
+			Player1: pairing.Player1,
+			Player2: pairing.Player2,
 		}
+		t.Games[game.ID] = game
 	}
 
-	slog.Info("Finished generating games and alerting players")
-	// Alert players in games
-	for gID, game := range t.Games {
-		if game.Player1 != nil {
-			game.Player1.MsgChan <- GameResponse{
-				Command: "gameStarted",
-				Payload: gID,
-			}
+	slog.Info("Finished generating games and alerting players", "tournamentID", t.ID, "match", t.CurMatch, "numGames", len(t.Games))
+	t.beginMatch()
+}
+
+// Players in seed order. Seeds come from player IDs so they are random but survive a restart.
+func (t *Tournament) players() []*Player {
+	players := make([]*Player, 0, len(t.WaitingRoom))
+	for _, player := range t.WaitingRoom {
+		players = append(players, player)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		if players[i].ID != players[j].ID {
+			return players[i].ID < players[j].ID
 		}
-		if game.Player2 != nil {
-			game.Player2.MsgChan <- GameResponse{
-				Command: "gameStarted",
-				Payload: gID,
-			}
+		return players[i].Username < players[j].Username
+	})
+	return players
+}
+
+// Standing is a copy of one player's record, safe to hand out of the loop
+type Standing struct {
+	Rank     int
+	Username string
+	Wins     int
+	Losses   int
+	Draws    int
+	Points   int
+	// Whether they have a game in progress in the current match
+	Playing bool
+}
+
+type LiveStandings struct {
+	Started bool
+	// Current match, counting from 1
+	Match   int
+	Players []Standing
+}
+
+// LiveStandings ranks the players by the format's standings, tiebreaks included
+func (t *Tournament) LiveStandings() LiveStandings {
+	ls := LiveStandings{Started: t.Started, Match: t.CurMatch + 1}
+	for i, p := range t.Config.Format.Standings(t.players()) {
+		s := Standing{Rank: i + 1, Username: p.Username, Wins: p.WinCount, Losses: p.Losses, Draws: p.Draws, Points: p.Points()}
+		if gID, ok := t.PlayerGames[p.Username]; ok {
+			_, s.Playing = t.Games[gID]
 		}
+		ls.Players = append(ls.Players, s)
 	}
+	return ls
 }
 
-func (t *Tournament) AcceptPlayerMove(username, move string) {
-	gameID := uuid.New().String()
-	// Check if game exists
-	if game, ok := t.Games[gameID]; ok {
-		//
-		if game.Player1.Username == username {
-			currentRound := 0
-			for i := range game.Rounds {
-				if game.Rounds[i].Player1Move == "" {
-					game.Rounds[i].Player1Move = move
-					currentRound = i
-
-					// If other player has already played, check winner
-					if game.Rounds[i].Player2Move != "" {
-						game.Rounds[i].Winner = getWinner(game.Rounds[i].Player1Move, game.Rounds[i].Player2Move)
-						p1wins, p2wins, _ := calculateStandings(game.Rounds)
-						if p1wins >= 3 {
-							game.WinnerUsername = game.Player1.Username
-							game.Player1.MsgChan <- GameResponse{
-								Command: "gameWon",
-								Payload: game.Rounds[currentRound],
-							}
-							game.Player2.MsgChan <- GameResponse{
-								Command: "gameLost",
-								Payload: game.Rounds[currentRound],
-							}
-						} else if p2wins >= 3 {
-							game.WinnerUsername = game.Player2.Username
-							game.Player1.MsgChan <- GameResponse{
-								Command: "gameLost",
-								Payload: game.Rounds[currentRound],
-							}
-							game.Player2.MsgChan <- GameResponse{
-								Command: "gameWon",
-								Payload: game.Rounds[currentRound],
-							}
-						} else if i == len(game.Rounds)-1 {
-							if p1wins == p2wins {
-								game.WinnerUsername = ""
-								game.Player1.MsgChan <- GameResponse{
-									Command: "gameDraw",
-									Payload: game.Rounds[currentRound],
-								}
-								game.Player2.MsgChan <- GameResponse{
-									Command: "gameDraw",
-									Payload: game.Rounds[currentRound],
-								}
-							} else if p1wins > p2wins {
-								game.WinnerUsername = game.Player1.Username
-								game.Player1.MsgChan <- GameResponse{
-									Command: "gameWon",
-									Payload: game.Rounds[currentRound],
-								}
-								game.Player2.MsgChan <- GameResponse{
-									Command: "gameLost",
-									Payload: game.Rounds[currentRound],
-								}
-							} else {
-								game.WinnerUsername = game.Player2.Username
-								game.Player1.MsgChan <- GameResponse{
-									Command: "gameLost",
-									Payload: game.Rounds[currentRound],
-								}
-								game.Player2.MsgChan <- GameResponse{
-									Command: "gameWon",
-									Payload: game.Rounds[currentRound],
-								}
-							}
-						}
-					} else {
-						game.Player1.MsgChan <- GameResponse{
-							Command: "moveAccepted",
-							Payload: game.Rounds[currentRound],
-						}
-					}
-					break
-				}
-			}
-			// Rounds are up, there is no winner
-			slog.Error("Player sent moves but rounds are up", "username", username)
-		} else if game.Player2.Username == username {
-			currentRound := 0
-			for i := range game.Rounds {
-				if game.Rounds[i].Player2Move == "" {
-					game.Rounds[i].Player2Move = move
-					currentRound = i
-
-					// If other player has already played, check winner
-					if game.Rounds[i].Player1Move != "" {
-						game.Rounds[i].Winner = getWinner(game.Rounds[i].Player1Move, game.Rounds[i].Player2Move)
-						p1wins, p2wins, _ := calculateStandings(game.Rounds)
-						if p1wins >= 3 {
-							game.WinnerUsername = game.Player1.Username
-							game.Player1.MsgChan <- GameResponse{
-								Command: "gameWon",
-								Payload: game.Rounds[currentRound],
-							}
-							game.Player2.MsgChan <- GameResponse{
-								Command: "gameLost",
-								Payload: game.Rounds[currentRound],
-							}
-						}
-						if p2wins >= 3 {
-							game.WinnerUsername = game.Player2.Username
-							game.Player1.MsgChan <- GameResponse{
-								Command: "gameLost",
-								Payload: game.Rounds[currentRound],
-							}
-							game.Player2.MsgChan <- GameResponse{
-								Command: "gameWon",
-								Payload: game.Rounds[currentRound],
-							}
-						} else if i == len(game.Rounds)-1 {
-							if p1wins == p2wins {
-								game.WinnerUsername = ""
-								game.Player1.MsgChan <- GameResponse{
-									Command: "gameDraw",
-									Payload: game.Rounds[currentRound],
-								}
-								game.Player2.MsgChan <- GameResponse{
-									Command: "gameDraw",
-									Payload: game.Rounds[currentRound],
-								}
-							} else if p1wins > p2wins {
-								game.WinnerUsername = game.Player1.Username
-								game.Player1.MsgChan <- GameResponse{
-									Command: "gameWon",
-									Payload: game.Rounds[currentRound],
-								}
-								game.Player2.MsgChan <- GameResponse{
-									Command: "gameLost",
-									Payload: game.Rounds[currentRound],
-								}
-							} else {
-								game.WinnerUsername = game.Player2.Username
-								game.Player1.MsgChan <- GameResponse{
-									Command: "gameLost",
-									Payload: game.Rounds[currentRound],
-								}
-								game.Player2.MsgChan <- GameResponse{
-									Command: "gameWon",
-									Payload: game.Rounds[currentRound],
-								}
-							}
-						}
-					} else {
-						game.Player2.MsgChan <- GameResponse{
-							Command: "moveAccepted",
-							Payload: game.Rounds[currentRound],
-						}
-					}
-					break
-				}
-			}
-			// Rounds are up, there is no winner
-			slog.Error("Player sent moves but rounds are up", "username", username)
+// Index, persist and announce the freshly paired games of the current match, then start the round clocks
+func (t *Tournament) beginMatch() {
+	t.indexGames()
+
+	// Byes are settled as soon as they are paired
+	now := time.Now()
+	for gID, game := range t.Games {
+		if game.Player1 == nil || game.Player2 == nil {
+			game.CalculateWinner(t.Config.Ruleset)
 		} else {
-			slog.Error("Player not found in game", "username", username)
+			game.RoundDeadline = now.Add(t.Config.RoundTimeout)
+		}
+		t.Games[gID] = game
+	}
+
+	t.persistNewGames()
+
+	// Alert players in games
+	for _, game := range t.Games {
+		game.Player1.Send(game.started(1, t.Config))
+		game.Player2.Send(game.started(2, t.Config))
+		t.publish(GameStarted{Game: game.snapshot()})
+		if game.Finished {
+			t.publish(GameFinished{Game: game.snapshot()})
+		}
+	}
+
+	t.advanceIfMatchOver()
+}
+
+// Move on to the next match as soon as every game in the current one is finished
+func (t *Tournament) advanceIfMatchOver() {
+	if t.isDone() {
+		return
+	}
+	for _, game := range t.Games {
+		if !game.Finished {
 			return
 		}
+	}
+	slog.Info("All games finished, ending match", "tournamentID", t.ID, "match", t.CurMatch)
+	t.EndMatch()
+}
+
+func (t *Tournament) isDone() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Forfeit is recorded as the move of a player who let a round time out
+const Forfeit = "forfeit"
+
+// Play a move in the player's current game, settling the round once both players have moved
+func (t *Tournament) AcceptPlayerMove(username, move string) error {
+	if !t.Config.Ruleset.MoveSet.Valid(move) {
+		return fmt.Errorf("%q is not a valid move", move)
+	}
+
+	gameID, ok := t.PlayerGames[username]
+	if !ok {
+		return errors.New("you are not in an active game")
+	}
+	game, ok := t.Games[gameID]
+	if !ok {
+		slog.Error("Player indexed to a game that does not exist", "username", username, "gameID", gameID)
+		return errors.New("you are not in an active game")
+	}
+	if game.Finished {
+		return errors.New("your game is already over")
+	}
+
+	slot := game.slot(username)
+	if slot == 0 {
+		slog.Error("Player not found in game", "username", username, "gameID", gameID)
+		return errors.New("you are not in an active game")
+	}
+	if game.opponent(slot) == nil {
+		return errors.New("you have a bye this match")
+	}
+
+	number := game.currentRound()
+	if number < 0 {
+		return errors.New("no rounds left to play")
+	}
+	round := &game.Rounds[number]
+	if mine, _ := round.moves(slot); mine != "" {
+		return errors.New("you already moved this round")
+	}
+	if slot == 1 {
+		round.Player1Move = move
+		game.Player1Idle = 0
+	} else {
+		round.Player2Move = move
+		game.Player2Idle = 0
+	}
+
+	player := game.player(slot)
+	player.Send(game.roundResponse(protocol.TypeMoveAccepted, number, slot))
+
+	if _, theirs := round.moves(slot); theirs == "" {
+		// Write the move through so a half played round is not lost
+		if err := t.Store.SaveRound(game.ID, number, *round); err != nil {
+			slog.Error("Error persisting round", "gameID", game.ID, "round", number, "error", err)
+		}
 		t.Games[gameID] = game
+		t.publish(MoveAccepted{GameID: game.ID, Username: username, Round: number})
+		return nil
+	}
+
+	t.settleRound(&game, number)
+	t.Games[gameID] = game
+	t.advanceIfMatchOver()
+	return nil
+}
+
+// Score a round both players have moved in (or been forfeited out of) and finish the game if it is decided
+func (t *Tournament) settleRound(game *Game, number int) {
+	round := &game.Rounds[number]
+	rules := t.Config.Ruleset
+	round.Winner = rules.Winner(round.Player1Move, round.Player2Move)
+
+	if err := t.Store.SaveRound(game.ID, number, *round); err != nil {
+		slog.Error("Error persisting round", "gameID", game.ID, "round", number, "error", err)
 	}
+	t.publish(RoundFinished{GameID: game.ID, Number: number, Round: *round})
+
+	// First to a majority wins, idle players forfeit, otherwise whoever is ahead once the rounds run out
+	p1wins, p2wins, _ := calculateStandings(game.Rounds)
+	lastRound := number == len(game.Rounds)-1
+	switch {
+	case p1wins >= rules.WinsNeeded():
+		game.finish(game.Player1.Username)
+	case p2wins >= rules.WinsNeeded():
+		game.finish(game.Player2.Username)
+	case game.Player1Idle >= MaxIdleRounds && game.Player2Idle >= MaxIdleRounds:
+		game.finish("")
+	case game.Player1Idle >= MaxIdleRounds:
+		game.finish(game.Player2.Username)
+	case game.Player2Idle >= MaxIdleRounds:
+		game.finish(game.Player1.Username)
+	case lastRound && p1wins > p2wins:
+		game.finish(game.Player1.Username)
+	case lastRound && p2wins > p1wins:
+		game.finish(game.Player2.Username)
+	case lastRound && rules.SuddenDeath && len(game.Rounds) < rules.BestOf+MaxSuddenDeathRounds:
+		// Tied, play on until someone takes a round
+		game.Rounds = append(game.Rounds, Round{})
+	case lastRound:
+		game.finish("")
+	}
+
+	if !game.Finished {
+		game.RoundDeadline = time.Now().Add(t.Config.RoundTimeout)
+		game.Player1.Send(game.roundResponse(protocol.TypeRoundFinished, number, 1))
+		game.Player2.Send(game.roundResponse(protocol.TypeRoundFinished, number, 2))
+		return
+	}
+	t.announceResult(game, number)
 }
 
-func (t *Tournament) JoinWaitingRoom(username string, player *Player) {
+// Tell both players how a finished game ended as of the given round, then persist and publish the result
+func (t *Tournament) announceResult(game *Game, number int) {
+	game.RoundDeadline = time.Time{}
+	switch game.WinnerUsername {
+	case "":
+		game.Player1.Send(game.roundResponse(protocol.TypeGameDraw, number, 1))
+		game.Player2.Send(game.roundResponse(protocol.TypeGameDraw, number, 2))
+	case game.Player1.Username:
+		game.Player1.Send(game.roundResponse(protocol.TypeGameWon, number, 1))
+		game.Player2.Send(game.roundResponse(protocol.TypeGameLost, number, 2))
+	default:
+		game.Player1.Send(game.roundResponse(protocol.TypeGameLost, number, 1))
+		game.Player2.Send(game.roundResponse(protocol.TypeGameWon, number, 2))
+	}
+	if err := t.Store.FinishGame(*game); err != nil {
+		slog.Error("Error persisting finished game", "gameID", game.ID, "error", err)
+	}
+	t.publish(GameFinished{Game: game.snapshot()})
+}
+
+// ResolveGame settles a disputed game in the current match by hand. Finished games are already
+// rated, so only games still in progress can be resolved. The match moves on if this was the last game left.
+func (t *Tournament) ResolveGame(gameID, winnerUsername string) (Game, error) {
+	game, ok := t.Games[gameID]
+	if !ok {
+		return Game{}, errors.New("game is not part of the current match")
+	}
+	if game.Player1 == nil || game.Player2 == nil {
+		return Game{}, errors.New("byes cannot be resolved")
+	}
+	if game.Finished {
+		return Game{}, errors.New("game is already finished")
+	}
+	if winnerUsername != "" && game.slot(winnerUsername) == 0 {
+		return Game{}, fmt.Errorf("%s is not playing in this game", winnerUsername)
+	}
+
+	// Report the score as of the last round anyone moved in
+	number := 0
+	for i, round := range game.Rounds {
+		if round.Player1Move != "" || round.Player2Move != "" {
+			number = i
+		}
+	}
+
+	slog.Info("Resolving game by hand", "tournamentID", t.ID, "gameID", gameID, "winner", winnerUsername)
+	game.finish(winnerUsername)
+	t.announceResult(&game, number)
+	t.Games[gameID] = game
+
+	resolved := game.snapshot()
+	t.advanceIfMatchOver()
+	return resolved, nil
+}
+
+// Point every player at their game in the current match
+func (t *Tournament) indexGames() {
+	t.PlayerGames = map[string]string{}
+	for gID, game := range t.Games {
+		for _, player := range []*Player{game.Player1, game.Player2} {
+			if player != nil {
+				t.PlayerGames[player.Username] = gID
+			}
+		}
+	}
+}
+
+// Write newly paired games through to the store before players are alerted
+func (t *Tournament) persistNewGames() {
+	for gID, game := range t.Games {
+		if game.Match != t.CurMatch {
+			continue
+		}
+		if err := t.Store.CreateGame(t.ID, game); err != nil {
+			slog.Error("Error persisting new game", "gameID", gID, "error", err)
+		}
+	}
+}
+
+func (t *Tournament) JoinWaitingRoom(username string, player *Player) error {
 	// Check if player already exists in waiting room
-	if _, ok := t.WaitingRoom[username]; !ok {
+	existing, ok := t.WaitingRoom[username]
+	if !ok {
+		// The format has already been laid out for whoever was here at the start
+		if t.Started {
+			return errors.New("tournament has already started")
+		}
 		t.WaitingRoom[username] = player
+		t.publish(PlayerJoined{Username: username})
+		return nil
+	}
+
+	// Reconnecting players keep their place, games hold on to the existing player so only swap the socket
+	existing.MsgChan = player.MsgChan
+	for _, game := range t.Games {
+		if game.Player1 == existing {
+			existing.Send(game.resumed(1))
+		} else if game.Player2 == existing {
+			existing.Send(game.resumed(2))
+		}
+	}
+	return nil
+}
+
+// Replace whoever has a socket open with the players who confirmed their registration. Confirmed
+// players who are not connected yet are still paired, they can pick up their game when they join.
+func (t *Tournament) seedWaitingRoom() {
+	confirmed, err := t.Store.ConfirmedPlayers(t.ID)
+	if err != nil {
+		slog.Error("Error loading confirmed players, starting with the waiting room", "tournamentID", t.ID, "error", err)
+		return
+	}
+
+	waitingRoom := map[string]*Player{}
+	for _, p := range confirmed {
+		if connected, ok := t.WaitingRoom[p.Username]; ok {
+			p.MsgChan = connected.MsgChan
+		}
+		waitingRoom[p.Username] = p
+	}
+
+	for username, p := range t.WaitingRoom {
+		if _, ok := waitingRoom[username]; ok {
+			continue
+		}
+		p.Send(GameResponse{
+			Command: protocol.TypeNotEntered,
+			Payload: protocol.NotEntered{Reason: "You did not check in for this tournament"},
+		})
+		t.publish(PlayerLeft{Username: username})
 	}
+	t.WaitingRoom = waitingRoom
 }
 
 func (t *Tournament) LeaveWaitingRoom(username string) {
 	// Check if player exists in waiting room
 	if _, ok := t.WaitingRoom[username]; ok {
 		delete(t.WaitingRoom, username)
+		t.publish(PlayerLeft{Username: username})
 	}
 }
 
-// After 5 games if there are all draws, we will not have a winner
-func (g *Game) CalculateWinner() string {
+// If the rounds are tied when the game is cut off, we will not have a winner
+func (g *Game) CalculateWinner(rules Ruleset) string {
 	// Check if we already have a winner
-	if g.WinnerUsername != "" {
+	if g.Finished || g.WinnerUsername != "" {
+		g.Finished = true
 		return g.WinnerUsername
 	}
 	if (g.Player1 == nil) && (g.Player2 == nil) {
+		g.finish("")
 		return ""
 	}
+	// Byes go to whoever showed up
 	if g.Player1 == nil {
-		return g.Player2.Username
+		g.finish(g.Player2.Username)
+		return g.WinnerUsername
 	}
 	if g.Player2 == nil {
-		return g.Player1.Username
+		g.finish(g.Player1.Username)
+		return g.WinnerUsername
 	}
 
 	// If not, count the rounds
 	p1 := 0
 	p2 := 0
 	for _, round := range g.Rounds {
-		winner := getWinner(round.Player1Move, round.Player2Move)
+		winner := rules.Winner(round.Player1Move, round.Player2Move)
 		if winner == 1 {
 			p1++
 		}
@@ -487,40 +812,79 @@ func (g *Game) CalculateWinner() string {
 		}
 	}
 	if p1 > p2 {
-		g.WinnerUsername = g.Player1.Username
+		g.finish(g.Player1.Username)
 	}
 	if p2 > p1 {
-		g.WinnerUsername = g.Player2.Username
+		g.finish(g.Player2.Username)
 	}
 	if p1 == p2 {
-		g.WinnerUsername = ""
+		g.finish("")
 	}
 	return g.WinnerUsername
 }
 
-func getWinner(move1, move2 string) int {
-	// No one played
-	if move1 == "" && move2 != "" {
-		return 0
+// RecordResult adds a finished game to both players' records
+func (g *Game) RecordResult() {
+	p1, p2 := g.Player1, g.Player2
+	switch {
+	case p1 == nil && p2 == nil:
+	case p1 == nil || p2 == nil:
+		// Byes count as a win
+		p := p1
+		if p == nil {
+			p = p2
+		}
+		p.WinCount++
+		p.History = append(p.History, Result{Outcome: Win})
+	case g.WinnerUsername == "":
+		p1.Draws++
+		p2.Draws++
+		p1.History = append(p1.History, Result{Opponent: p2.Username, Outcome: Draw})
+		p2.History = append(p2.History, Result{Opponent: p1.Username, Outcome: Draw})
+	case g.WinnerUsername == p1.Username:
+		p1.WinCount++
+		p2.Losses++
+		p1.History = append(p1.History, Result{Opponent: p2.Username, Outcome: Win})
+		p2.History = append(p2.History, Result{Opponent: p1.Username, Outcome: Loss})
+	default:
+		p2.WinCount++
+		p1.Losses++
+		p2.History = append(p2.History, Result{Opponent: p1.Username, Outcome: Win})
+		p1.History = append(p1.History, Result{Opponent: p2.Username, Outcome: Loss})
 	}
+}
 
-	if move1 != "" {
-		return 2
-	}
+func (g *Game) finish(winnerUsername string) {
+	g.WinnerUsername = winnerUsername
+	g.Finished = true
+}
 
-	if move2 != "" {
+// Returns 1 or 2 for the player's slot in the game, or 0 if they are not playing in it
+func (g *Game) slot(username string) int {
+	if g.Player1 != nil && g.Player1.Username == username {
 		return 1
 	}
-
-	if move1 == move2 {
-		return 0
+	if g.Player2 != nil && g.Player2.Username == username {
+		return 2
 	}
+	return 0
+}
 
-	if (move1 == "rock" && move2 == "scissors") || (move1 == "scissors" && move2 == "paper") || (move1 == "paper" && move2 == "rock") {
-		return 1
+func (g *Game) player(slot int) *Player {
+	if slot == 1 {
+		return g.Player1
 	}
+	return g.Player2
+}
 
-	return 2
+// Returns the first round still waiting on a move, or -1 once every round has been played
+func (g *Game) currentRound() int {
+	for i, round := range g.Rounds {
+		if round.Player1Move == "" || round.Player2Move == "" {
+			return i
+		}
+	}
+	return -1
 }
 
 // Get the standings of a game's rounds
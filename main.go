@@ -2,12 +2,17 @@ package main
 
 import (
 	"Roshamble/internal/handlers"
+	"Roshamble/internal/matchmaking"
 	"Roshamble/internal/routes"
+	"Roshamble/internal/scheduler"
+	"Roshamble/internal/services"
+	"Roshamble/internal/sms"
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"log/slog"
-	"time"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq"
@@ -19,6 +24,11 @@ var db *sql.DB
 func main() {
 	initDB()
 
+	// Management commands share the database but not the server
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
 	r := gin.Default()
 
 	// Load templates
@@ -27,7 +37,24 @@ func main() {
 	// Serve static files
 	r.Static("/assets", "./assets")
 
-	handler := &handlers.Handler{DB: db}
+	sender, err := sms.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure SMS: %v", err)
+	}
+
+	handler := &handlers.Handler{DB: db, SMS: sender}
+	handler.Locks = services.NewTournamentLocker(db, handler.DropTournament)
+	go handler.Locks.Run(context.Background())
+	handler.Matchmaker = matchmaking.New(handler.StartQuickplay)
+	go handler.Matchmaker.Run(context.Background())
+
+	// Pick up any tournaments that were running when the server went down
+	if err := handler.RestoreTournaments(); err != nil {
+		slog.Error("Error restoring running tournaments", "error", err)
+	}
+
+	// Create tournaments from their templates and start them on time
+	go scheduler.New(db, handler).Run(context.Background())
 
 	// Add public routes
 	routes.PublicRoutes(r, handler)
@@ -35,6 +62,9 @@ func main() {
 	// Add Protected routes
 	routes.ProtectedRoutes(r, handler)
 
+	// Add moderator and admin routes
+	routes.AdminRoutes(r, handler)
+
 	port := ":4000"
 	fmt.Printf("Server running at http://localhost%s\n", port)
 	r.Run(port)
@@ -48,19 +78,6 @@ func initDB() {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	// Cron jobs to debug tournament behavior
-	go func() {
-		ticker := time.NewTicker(time.Second * 20)
-
-		for range ticker.C {
-			_, err := db.Exec("INSERT INTO tournaments (name, prize, prize_url, description) VALUES ($1, $2, $3, $4)", "Test", "A Rivian RT-1", "https://example.com", "A test tournament")
-			if err != nil {
-				slog.Error("Error creating test tournaments ", "error ", err.Error())
-			}
-			slog.Info("Created tournament")
-		}
-	}()
-
 	db.SetMaxOpenConns(25)
 	db.SetMaxIdleConns(25)
 	db.SetConnMaxLifetime(0)
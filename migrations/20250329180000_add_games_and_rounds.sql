@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE games (
+    id UUID PRIMARY KEY,
+    tournament_id INT NOT NULL REFERENCES tournaments(id) ON DELETE CASCADE,
+    match INT NOT NULL,
+    player1_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    player2_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    winner_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    -- in_progress, finished, draw or bye
+    status VARCHAR(20) NOT NULL DEFAULT 'in_progress',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX games_tournament_id_idx ON games (tournament_id, match);
+
+CREATE TABLE rounds (
+    id SERIAL PRIMARY KEY,
+    game_id UUID NOT NULL REFERENCES games(id) ON DELETE CASCADE,
+    round_number INT NOT NULL,
+    player1_move VARCHAR(20),
+    player2_move VARCHAR(20),
+    -- 0 for a draw or unfinished round, otherwise the winning player slot
+    winner INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT unique_game_round UNIQUE (game_id, round_number)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE rounds;
+DROP TABLE games;
+-- +goose StatementEnd
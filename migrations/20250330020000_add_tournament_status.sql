@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+UPDATE tournaments SET status = CASE WHEN winner_id IS NOT NULL THEN 'finished' ELSE 'scheduled' END WHERE status IS NULL;
+
+ALTER TABLE tournaments
+ALTER COLUMN status SET DEFAULT 'scheduled',
+ALTER COLUMN status SET NOT NULL,
+ADD CONSTRAINT tournament_status_check CHECK (status IN ('scheduled', 'open', 'running', 'finished', 'cancelled'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tournaments
+DROP CONSTRAINT tournament_status_check,
+ALTER COLUMN status DROP NOT NULL,
+ALTER COLUMN status DROP DEFAULT;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE tournaments ADD COLUMN round_timeout_seconds INT NOT NULL DEFAULT 15 CHECK (round_timeout_seconds > 0);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tournaments DROP COLUMN round_timeout_seconds;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE tournaments
+ADD COLUMN format VARCHAR(30) NOT NULL DEFAULT 'swiss'
+CHECK (format IN ('swiss', 'single_elimination', 'double_elimination', 'round_robin'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tournaments DROP COLUMN format;
+-- +goose StatementEnd
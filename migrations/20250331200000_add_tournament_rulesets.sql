@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE tournaments
+ADD COLUMN best_of INT NOT NULL DEFAULT 5 CHECK (best_of > 0 AND best_of % 2 = 1),
+ADD COLUMN sudden_death BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN move_set VARCHAR(20) NOT NULL DEFAULT 'rps' CHECK (move_set IN ('rps', 'rpsls'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tournaments
+DROP COLUMN best_of,
+DROP COLUMN sudden_death,
+DROP COLUMN move_set;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE tournament_players (
+    tournament_id INT NOT NULL REFERENCES tournaments(id) ON DELETE CASCADE,
+    player_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- registered, or checked_in once the player confirms they are playing
+    status VARCHAR(20) NOT NULL DEFAULT 'registered',
+    registered_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    checked_in_at TIMESTAMP,
+    PRIMARY KEY (tournament_id, player_id),
+    CONSTRAINT tournament_player_status_check CHECK (status IN ('registered', 'checked_in'))
+);
+
+CREATE INDEX tournament_players_player_id_idx ON tournament_players (player_id);
+
+ALTER TABLE tournaments
+-- NULL for no limit
+ADD COLUMN max_players INT,
+-- NULL to keep registration open until the tournament starts
+ADD COLUMN registration_closes_at TIMESTAMP,
+-- Check-in opens this many minutes before the start, 0 to count every registration as confirmed
+ADD COLUMN check_in_minutes INT NOT NULL DEFAULT 15;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tournaments
+DROP COLUMN check_in_minutes,
+DROP COLUMN registration_closes_at,
+DROP COLUMN max_players;
+
+DROP TABLE tournament_players;
+-- +goose StatementEnd
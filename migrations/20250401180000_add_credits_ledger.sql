@@ -0,0 +1,53 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE credit_accounts (
+    id SERIAL PRIMARY KEY,
+    -- Set for player wallets
+    user_id UUID UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    -- Set for the house accounts credits flow in and out of
+    name VARCHAR(50) UNIQUE,
+    -- Running total of the account's entries
+    balance BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT credit_account_owner_check CHECK ((user_id IS NULL) <> (name IS NULL)),
+    -- Players can never spend credits they do not have, house accounts go negative as they issue credits
+    CONSTRAINT credit_account_balance_check CHECK (user_id IS NULL OR balance >= 0)
+);
+
+INSERT INTO credit_accounts (name) VALUES ('entry_fees'), ('grants');
+
+CREATE TABLE credit_transactions (
+    id SERIAL PRIMARY KEY,
+    -- Retrying a transaction with the same key is a no-op
+    idempotency_key VARCHAR(255) NOT NULL UNIQUE,
+    -- entry_fee, refund, grant, ...
+    kind VARCHAR(30) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    tournament_id INT REFERENCES tournaments(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Every transaction has entries summing to zero
+CREATE TABLE credit_entries (
+    id SERIAL PRIMARY KEY,
+    transaction_id INT NOT NULL REFERENCES credit_transactions(id) ON DELETE CASCADE,
+    account_id INT NOT NULL REFERENCES credit_accounts(id) ON DELETE CASCADE,
+    amount BIGINT NOT NULL
+);
+
+CREATE INDEX credit_entries_account_id_idx ON credit_entries (account_id, transaction_id);
+
+ALTER TABLE tournaments ADD COLUMN entry_fee INT NOT NULL DEFAULT 0 CHECK (entry_fee >= 0);
+
+-- What the player paid to register, refunded if they leave or the tournament is cancelled
+ALTER TABLE tournament_players ADD COLUMN entry_fee INT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tournament_players DROP COLUMN entry_fee;
+ALTER TABLE tournaments DROP COLUMN entry_fee;
+DROP TABLE credit_entries;
+DROP TABLE credit_transactions;
+DROP TABLE credit_accounts;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE referrals (
+    id SERIAL PRIMARY KEY,
+    referrer_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    referred_id UUID UNIQUE REFERENCES users(id) ON DELETE SET NULL,
+    -- A phone number can only ever be referred once, even if the account is deleted and made again
+    phone VARCHAR(20) NOT NULL UNIQUE,
+    -- pending until the referred player finishes a tournament, then rewarded, or capped if the referrer hit their limit
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    rewarded_at TIMESTAMP,
+    CONSTRAINT referral_status_check CHECK (status IN ('pending', 'rewarded', 'capped'))
+);
+
+CREATE INDEX referrals_referrer_id_idx ON referrals (referrer_id, rewarded_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE referrals;
+-- +goose StatementEnd
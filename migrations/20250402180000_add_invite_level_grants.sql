@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+UPDATE users SET invite_level = 1 WHERE invite_level IS NULL;
+
+ALTER TABLE users
+ALTER COLUMN invite_level SET NOT NULL;
+
+-- Levels handed out by hand, a player never drops below the highest level they were granted
+CREATE TABLE invite_level_grants (
+    id SERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    level INT NOT NULL,
+    granted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX invite_level_grants_user_id_idx ON invite_level_grants (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE invite_level_grants;
+
+ALTER TABLE users
+ALTER COLUMN invite_level DROP NOT NULL;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE sms_messages (
+    id SERIAL PRIMARY KEY,
+    phone VARCHAR(20) NOT NULL,
+    -- Name of the template the body was rendered from, bodies hold codes so they are not stored
+    template VARCHAR(50) NOT NULL,
+    -- pending, queued, sent, delivered, undelivered or failed
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    provider_id VARCHAR(64) UNIQUE,
+    error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX sms_messages_phone_idx ON sms_messages (phone, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE sms_messages;
+-- +goose StatementEnd
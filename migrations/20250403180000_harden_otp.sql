@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Outstanding codes are only valid for minutes, nobody misses them
+DELETE FROM otp;
+
+ALTER TABLE otp
+DROP COLUMN code,
+ADD COLUMN code_hash VARCHAR(64) NOT NULL,
+-- Wrong guesses against this code, it is thrown away after too many
+ADD COLUMN attempts INT NOT NULL DEFAULT 0,
+ALTER COLUMN phone TYPE VARCHAR(20);
+
+-- Failed verifications per phone number and per IP address, keyed "phone:+15551234567" or "ip:203.0.113.7"
+CREATE TABLE otp_attempts (
+    key VARCHAR(100) PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    window_start TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP
+);
+
+-- Store phone numbers in E.164, assuming North American numbers for the bare ten digit ones already stored
+UPDATE users SET phone = '+1' || regexp_replace(phone, '\D', '', 'g')
+WHERE regexp_replace(phone, '\D', '', 'g') ~ '^\d{10}$' AND phone NOT LIKE '+%'
+AND NOT EXISTS (SELECT 1 FROM users u WHERE u.phone = '+1' || regexp_replace(users.phone, '\D', '', 'g'));
+
+UPDATE users SET phone = '+' || regexp_replace(phone, '\D', '', 'g')
+WHERE regexp_replace(phone, '\D', '', 'g') ~ '^1\d{10}$' AND phone NOT LIKE '+%'
+AND NOT EXISTS (SELECT 1 FROM users u WHERE u.phone = '+' || regexp_replace(users.phone, '\D', '', 'g'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE otp_attempts;
+
+DELETE FROM otp;
+
+ALTER TABLE otp
+DROP COLUMN attempts,
+DROP COLUMN code_hash,
+ADD COLUMN code VARCHAR(6) NOT NULL,
+ALTER COLUMN phone TYPE VARCHAR(15);
+-- +goose StatementEnd
@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Nothing ever wrote sessions, start from a clean table
+DELETE FROM user_sessions;
+
+ALTER TABLE user_sessions
+DROP COLUMN token,
+-- Hash of the refresh token, the token itself only lives in the player's cookie
+ADD COLUMN refresh_token_hash VARCHAR(64) NOT NULL UNIQUE,
+-- The token before the last rotation, still accepted briefly so concurrent requests do not sign the player out
+ADD COLUMN previous_token_hash VARCHAR(64),
+ADD COLUMN rotated_at TIMESTAMPTZ,
+ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+ADD COLUMN ip VARCHAR(64) NOT NULL DEFAULT '',
+ADD COLUMN last_used_at TIMESTAMPTZ DEFAULT now(),
+ADD COLUMN revoked_at TIMESTAMPTZ,
+ALTER COLUMN user_id SET NOT NULL;
+
+CREATE INDEX user_sessions_user_id_idx ON user_sessions (user_id);
+CREATE INDEX user_sessions_previous_token_hash_idx ON user_sessions (previous_token_hash);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM user_sessions;
+
+ALTER TABLE user_sessions
+DROP COLUMN revoked_at,
+DROP COLUMN last_used_at,
+DROP COLUMN ip,
+DROP COLUMN user_agent,
+DROP COLUMN rotated_at,
+DROP COLUMN previous_token_hash,
+DROP COLUMN refresh_token_hash,
+ADD COLUMN token TEXT UNIQUE NOT NULL,
+ALTER COLUMN user_id DROP NOT NULL;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Audit trail of every role granted or revoked
+CREATE TABLE role_changes (
+    id SERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    -- grant or revoke
+    action VARCHAR(10) NOT NULL CHECK (action IN ('grant', 'revoke')),
+    -- NULL when changed from the command line
+    changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX role_changes_user_id_idx ON role_changes (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE role_changes;
+-- +goose StatementEnd
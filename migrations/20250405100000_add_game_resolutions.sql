@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Audit trail of disputed games settled by a moderator
+CREATE TABLE game_resolutions (
+    id SERIAL PRIMARY KEY,
+    game_id UUID NOT NULL REFERENCES games(id) ON DELETE CASCADE,
+    tournament_id INT NOT NULL REFERENCES tournaments(id) ON DELETE CASCADE,
+    -- NULL when the game was called a draw
+    winner_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    resolved_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX game_resolutions_game_id_idx ON game_resolutions (game_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE game_resolutions;
+-- +goose StatementEnd
@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Recurring tournaments, the scheduler creates a tournament from the template for every slot of its schedule
+CREATE TABLE tournament_templates (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    emoji VARCHAR(10),
+    prize VARCHAR(225) NOT NULL,
+    prize_url VARCHAR(255),
+    invite_level INT NOT NULL DEFAULT 0,
+    location VARCHAR(255),
+    round_timeout_seconds INT NOT NULL DEFAULT 15 CHECK (round_timeout_seconds > 0),
+    format VARCHAR(30) NOT NULL DEFAULT 'swiss' CHECK (format IN ('swiss', 'single_elimination', 'double_elimination', 'round_robin')),
+    best_of INT NOT NULL DEFAULT 5 CHECK (best_of > 0 AND best_of % 2 = 1),
+    sudden_death BOOLEAN NOT NULL DEFAULT FALSE,
+    move_set VARCHAR(20) NOT NULL DEFAULT 'rps' CHECK (move_set IN ('rps', 'rpsls')),
+    max_players INT,
+    check_in_minutes INT NOT NULL DEFAULT 15,
+    entry_fee INT NOT NULL DEFAULT 0 CHECK (entry_fee >= 0),
+    -- Cron expression: minute hour day-of-month month day-of-week
+    schedule VARCHAR(100) NOT NULL,
+    -- IANA zone the schedule is read in
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    -- Tournaments are created, and open for registration, this long before they start
+    create_ahead_hours INT NOT NULL DEFAULT 24 CHECK (create_ahead_hours > 0),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ DEFAULT now()
+);
+
+ALTER TABLE tournaments ADD COLUMN template_id INT REFERENCES tournament_templates(id) ON DELETE SET NULL;
+
+-- One tournament per template slot, however many servers are scheduling
+CREATE UNIQUE INDEX tournaments_template_slot_idx ON tournaments (template_id, start_date);
+
+INSERT INTO tournament_templates (name, description, emoji, prize, format, invite_level, schedule, create_ahead_hours) VALUES
+    ('Daily Showdown', 'A quick swiss tournament every day at noon', '✊', 'Bragging rights', 'swiss', 0, '0 12 * * *', 24),
+    ('Weekly Finals', 'The week''s big one, single elimination on Sunday evening', '🏆', 'A Rivian RT-1', 'single_elimination', 2, '0 18 * * 0', 72);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX tournaments_template_slot_idx;
+ALTER TABLE tournaments DROP COLUMN template_id;
+DROP TABLE tournament_templates;
+-- +goose StatementEnd
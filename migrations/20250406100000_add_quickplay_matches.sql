@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+-- One-off games between two players paired by the matchmaker
+CREATE TABLE quickplay_matches (
+    id SERIAL PRIMARY KEY,
+    mode VARCHAR(20) NOT NULL,
+    player1_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    player2_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    -- running, finished or cancelled
+    status VARCHAR(20) NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'finished', 'cancelled')),
+    -- NULL for a draw
+    winner_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    finished_at TIMESTAMPTZ
+);
+
+-- Games belong to either a tournament or a quickplay match
+ALTER TABLE games
+ALTER COLUMN tournament_id DROP NOT NULL,
+ADD COLUMN quickplay_match_id INT REFERENCES quickplay_matches(id) ON DELETE CASCADE,
+ADD CONSTRAINT games_owner_check CHECK ((tournament_id IS NULL) <> (quickplay_match_id IS NULL));
+
+CREATE INDEX games_quickplay_match_id_idx ON games (quickplay_match_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM games WHERE quickplay_match_id IS NOT NULL;
+ALTER TABLE games
+DROP CONSTRAINT games_owner_check,
+DROP COLUMN quickplay_match_id,
+ALTER COLUMN tournament_id SET NOT NULL;
+DROP TABLE quickplay_matches;
+-- +goose StatementEnd
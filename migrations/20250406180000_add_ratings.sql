@@ -0,0 +1,59 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Ratings are kept per season, the next season starts from a softened copy of the last one
+CREATE TABLE seasons (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- NULL while the season is running
+    ended_at TIMESTAMPTZ
+);
+
+-- Only one season runs at a time
+CREATE UNIQUE INDEX seasons_current_idx ON seasons ((ended_at IS NULL)) WHERE ended_at IS NULL;
+
+CREATE TABLE player_ratings (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    season_id INT NOT NULL REFERENCES seasons(id) ON DELETE CASCADE,
+    rating DOUBLE PRECISION NOT NULL,
+    deviation DOUBLE PRECISION NOT NULL,
+    volatility DOUBLE PRECISION NOT NULL,
+    games INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ DEFAULT now(),
+    PRIMARY KEY (user_id, season_id)
+);
+
+CREATE INDEX player_ratings_season_rating_idx ON player_ratings (season_id, rating DESC);
+
+-- Every rating change and the game that caused it
+CREATE TABLE rating_history (
+    id SERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    season_id INT NOT NULL REFERENCES seasons(id) ON DELETE CASCADE,
+    game_id UUID NOT NULL REFERENCES games(id) ON DELETE CASCADE,
+    rating_before DOUBLE PRECISION NOT NULL,
+    rating_after DOUBLE PRECISION NOT NULL,
+    deviation DOUBLE PRECISION NOT NULL,
+    volatility DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    -- A game is only ever rated once
+    UNIQUE (game_id, user_id)
+);
+
+CREATE INDEX rating_history_user_id_idx ON rating_history (user_id, created_at);
+
+-- Ranked quickplay games count towards ratings, casual ones do not
+ALTER TABLE quickplay_matches
+ADD COLUMN ranked BOOLEAN NOT NULL DEFAULT FALSE;
+
+INSERT INTO seasons (name) VALUES ('Season 1');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE quickplay_matches
+DROP COLUMN ranked;
+DROP TABLE rating_history;
+DROP TABLE player_ratings;
+DROP TABLE seasons;
+-- +goose StatementEnd